@@ -5,9 +5,29 @@ import (
 	"github.com/Elvenson/xgboost-go/protobuf"
 )
 
-// Activation is an interface that an activation needs to implement.
+// Activation is an interface that an activation needs to implement. Custom activations, for example a calibration
+// function, can be passed to the loaders or assigned to the Activation field of inference.Ensemble after loading.
 type Activation interface {
+	// Transform receives the raw prediction of a row, one value per class (1 value for binary classification and
+	// regression) base score included, and returns the final scores. It may modify rawPrediction in place.
 	Transform(rawPrediction mat.Vector) (mat.Vector, error)
+	// Type returns activation type, custom activations should return protobuf.ActivateType_UNKNOWN.
 	Type() protobuf.ActivateType
 	Name() string
 }
+
+// FromObjective returns the activation matching a DMLC XGBoost objective name: `binary:logistic` and `reg:logistic`
+// return Logistic, `multi:softmax` and `multi:softprob` return Softmax, `count:poisson`, `reg:gamma` and
+// `reg:tweedie` return Exp. Any other objective, like `reg:squarederror`, returns Raw.
+func FromObjective(objective string) Activation {
+	switch objective {
+	case "binary:logistic", "reg:logistic":
+		return &Logistic{}
+	case "multi:softmax", "multi:softprob":
+		return &Softmax{}
+	case "count:poisson", "reg:gamma", "reg:tweedie":
+		return &Exp{}
+	default:
+		return &Raw{}
+	}
+}
@@ -0,0 +1,32 @@
+package activation
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Elvenson/xgboost-go/mat"
+	"github.com/Elvenson/xgboost-go/protobuf"
+)
+
+// Exp is struct contains necessary data for doing exponential calculation, used by log link objectives like
+// count:poisson, reg:gamma and reg:tweedie. For now is empty.
+type Exp struct{}
+
+// Transform passes prediction through exponential function.
+func (a *Exp) Transform(rawPredictions mat.Vector) (mat.Vector, error) {
+	if len(rawPredictions) != 1 {
+		return mat.Vector{}, fmt.Errorf("prediction should have only 1 dimension got %d", len(rawPredictions))
+	}
+	rawPredictions[0] = math.Exp(rawPredictions[0])
+	return rawPredictions, nil
+}
+
+// Type returns activation type.
+func (a *Exp) Type() protobuf.ActivateType {
+	return protobuf.ActivateType_EXP
+}
+
+// Name returns activation name.
+func (a *Exp) Name() string {
+	return protobuf.ActivateType_name[int32(protobuf.ActivateType_EXP)]
+}
@@ -12,25 +12,24 @@ import (
 // for now is empty.
 type Softmax struct{}
 
-// softmax function.
+// softmax function, it transforms vector in place.
 func softmax(vector mat.Vector) mat.Vector {
 	sum := 0.0
-	r := make([]float64, len(vector))
 	for i, v := range vector {
 		exp := math.Exp(v)
-		r[i] = exp
+		vector[i] = exp
 		sum += exp
 	}
 	if sum != 0.0 {
 		inverseSum := 1.0 / sum
-		for i := range r {
-			r[i] *= inverseSum
+		for i := range vector {
+			vector[i] *= inverseSum
 		}
 	}
-	return r
+	return vector
 }
 
-// Transform passes prediction through softmax function.
+// Transform passes prediction through softmax function, rawPredictions is transformed in place.
 func (a *Softmax) Transform(rawPredictions mat.Vector) (mat.Vector, error) {
 	if len(rawPredictions) == 0 {
 		return mat.Vector{}, fmt.Errorf("prediction should have at least 1 dimension")
@@ -0,0 +1,54 @@
+package inference
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// CategoryVocabulary maps the index of a categorical feature to its category names, the position of a name in the
+// list is the category code the model is trained with, like the categories of a pandas categorical column.
+type CategoryVocabulary map[int][]string
+
+// code returns the category code of a category name of a feature.
+func (v CategoryVocabulary) code(feature int, category string) (int, error) {
+	categories, ok := v[feature]
+	if !ok {
+		return 0, fmt.Errorf("feature %d has no category vocabulary", feature)
+	}
+	for code, name := range categories {
+		if name == category {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown category %q of feature %d", category, feature)
+}
+
+// PredictMixed predicts transformed scores for a single feature vector mixing numeric and categorical features, keyed
+// by feature index. Numeric features are float64 or int values, categorical features are category names translated
+// into category codes through vocabulary, then categorical splits route them by set membership. Absent features and
+// nil values are missing values. Models do not record category names, so vocabulary must be supplied, for example
+// from the categories of the training data frame.
+func (e *Ensemble) PredictMixed(features map[int]interface{}, vocabulary CategoryVocabulary) (mat.Vector, error) {
+	sparse := make(mat.SparseVector, len(features))
+	for idx, value := range features {
+		switch v := value.(type) {
+		case nil:
+			sparse[idx] = math.NaN()
+		case float64:
+			sparse[idx] = v
+		case int:
+			sparse[idx] = float64(v)
+		case string:
+			code, err := vocabulary.code(idx, v)
+			if err != nil {
+				return mat.Vector{}, err
+			}
+			sparse[idx] = float64(code)
+		default:
+			return mat.Vector{}, fmt.Errorf("feature %d has value of unsupported type %T", idx, value)
+		}
+	}
+	return e.PredictSparse(sparse)
+}
@@ -0,0 +1,125 @@
+package inference
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// featureIndexer is implemented by base models knowing feature names, FeatureIndices returns nil if the model is
+// loaded without feature map.
+type featureIndexer interface {
+	FeatureIndices() map[string]int
+}
+
+// PredictCSV reads comma separated rows of feature values from r and writes the transformed scores of every row to
+// w, one comma separated line per row. Rows are streamed so the input is never fully held in memory. Empty cells are
+// missing values.
+//
+// If the first row is not numeric it is a header. Columns are then mapped to feature indices by name through the
+// feature map of the model, columns not in the feature map like ids or labels are skipped. If the model is not
+// loaded with a feature map, columns are used in order as without header.
+func (e *Ensemble) PredictCSV(r io.Reader, w io.Writer) error {
+	if e.NumClasses() == 0 {
+		return fmt.Errorf("0 class please check your model")
+	}
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	writer := bufio.NewWriter(w)
+
+	// columns maps csv columns to feature indices, -1 skips a column. It is nil when columns are in feature order.
+	var columns []int
+	row := make(mat.Vector, e.NumFeatures())
+	out := make([]byte, 0, 64)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if line == 1 && isHeader(record) {
+			columns = e.csvColumns(record)
+			continue
+		}
+
+		for i := range row {
+			row[i] = math.NaN()
+		}
+		for i, cell := range record {
+			idx := i
+			if columns != nil {
+				// the csv reader checks every line has as many columns as the header.
+				idx = columns[i]
+			}
+			if idx < 0 || idx >= len(row) {
+				continue
+			}
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return fmt.Errorf("line %d column %d: %w", line, i, err)
+			}
+			row[idx] = v
+		}
+
+		pred, err := e.PredictRow(row)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		out = out[:0]
+		for i, p := range pred {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = strconv.AppendFloat(out, p, 'g', -1, 64)
+		}
+		out = append(out, '\n')
+		if _, err := writer.Write(out); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// isHeader reports whether a csv record has a non empty cell that is not a number.
+func isHeader(record []string) bool {
+	for _, cell := range record {
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// csvColumns maps header names to feature indices, it returns nil if the model has no feature map.
+func (e *Ensemble) csvColumns(header []string) []int {
+	indexer, ok := e.EnsembleBase.(featureIndexer)
+	if !ok || indexer.FeatureIndices() == nil {
+		return nil
+	}
+	indices := indexer.FeatureIndices()
+	columns := make([]int, len(header))
+	for i, name := range header {
+		idx, ok := indices[strings.TrimSpace(name)]
+		if !ok {
+			idx = -1
+		}
+		columns[i] = idx
+	}
+	return columns
+}
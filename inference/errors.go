@@ -0,0 +1,13 @@
+package inference
+
+import "errors"
+
+// Sentinel errors returned by loaders and models, errors wrapping them can be checked with errors.Is.
+var (
+	// ErrNoTrees is returned when loading a model without tree.
+	ErrNoTrees = errors.New("no trees")
+	// ErrClassMismatch is returned when the number of classes does not match the model, its trees or other models.
+	ErrClassMismatch = errors.New("class mismatch")
+	// ErrFeatureNotFound is returned when a feature name is not in the feature map.
+	ErrFeatureNotFound = errors.New("cannot find feature")
+)
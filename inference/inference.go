@@ -1,7 +1,14 @@
 package inference
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
 
 	"github.com/Elvenson/xgboost-go/activation"
 	"github.com/Elvenson/xgboost-go/mat"
@@ -11,17 +18,118 @@ import (
 // EnsembleBase contains interface of a base model.
 type EnsembleBase interface {
 	PredictInner(features mat.SparseVector) (mat.Vector, error)
+	PredictInnerDense(features mat.Vector, predictions mat.Vector) error
 	Name() string
 	NumClasses() int
+	NumFeatures() int
 }
 
 // Ensemble struct contains ensemble model interface that a model needs to implement.
+// BaseScore is the global bias added to the raw prediction of every class before the activation.
+//
+// Prediction methods do not modify the model, scratch buffers are allocated per call or taken from a pool, so an
+// Ensemble can be shared by many goroutines as long as its fields and its trees are not modified meanwhile.
+// Custom activations must be safe for concurrent use as well.
 type Ensemble struct {
 	EnsembleBase
 	activation.Activation
+	BaseScore float64
+
+	// missing is the value marking missing features besides NaN when hasMissing is set.
+	missing    float64
+	hasMissing bool
+}
+
+// SetMissingValue sets a value marking missing features in the inputs besides NaN, like the missing parameter of
+// XGBoost, for datasets encoding missing values as -999 or 0 for example. Features equal to v take the missing branch
+// of the trees and do not contribute to linear models. Setting NaN restores the default of NaN only. Inputs are copied
+// to translate the value, so dense predictions allocate once a missing value is set.
+func (e *Ensemble) SetMissingValue(v float64) {
+	e.missing = v
+	e.hasMissing = !math.IsNaN(v)
+}
+
+// MissingValue returns the value marking missing features, NaN by default.
+func (e *Ensemble) MissingValue() float64 {
+	if !e.hasMissing {
+		return math.NaN()
+	}
+	return e.missing
+}
+
+// sparseMissing returns features with values equal to the missing value replaced by NaN.
+func (e *Ensemble) sparseMissing(features mat.SparseVector) mat.SparseVector {
+	if !e.hasMissing {
+		return features
+	}
+	translated := make(mat.SparseVector, len(features))
+	for idx, v := range features {
+		if v == e.missing {
+			v = math.NaN()
+		}
+		translated[idx] = v
+	}
+	return translated
+}
+
+// denseMissing returns features with values equal to the missing value replaced by NaN.
+func (e *Ensemble) denseMissing(features mat.Vector) mat.Vector {
+	if !e.hasMissing {
+		return features
+	}
+	translated := make(mat.Vector, len(features))
+	for i, v := range features {
+		if v == e.missing {
+			v = math.NaN()
+		}
+		translated[i] = v
+	}
+	return translated
+}
+
+// predictInner returns raw prediction of a sparse feature vector including base score.
+func (e *Ensemble) predictInner(features mat.SparseVector) (mat.Vector, error) {
+	pred, err := e.PredictInner(e.sparseMissing(features))
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	if len(pred) != e.NumClasses() {
+		return mat.Vector{}, fmt.Errorf("number of predicted value (%d) must match number of classes (%d)",
+			len(pred), e.NumClasses())
+	}
+	e.addBaseScore(pred)
+	return pred, nil
+}
+
+// predictInnerDense writes raw prediction of a dense feature vector including base score into predictions.
+func (e *Ensemble) predictInnerDense(features mat.Vector, predictions mat.Vector) error {
+	if err := e.checkDenseFeatures(features); err != nil {
+		return err
+	}
+	if err := e.PredictInnerDense(e.denseMissing(features), predictions); err != nil {
+		return err
+	}
+	e.addBaseScore(predictions)
+	return nil
+}
+
+// checkDenseFeatures checks a dense feature vector has enough features for the model.
+func (e *Ensemble) checkDenseFeatures(features mat.Vector) error {
+	if len(features) < e.NumFeatures() {
+		return fmt.Errorf("expected at least %d features, got %d", e.NumFeatures(), len(features))
+	}
+	return nil
+}
+
+// addBaseScore adds base score to raw prediction of every class.
+func (e *Ensemble) addBaseScore(predictions mat.Vector) {
+	for i := range predictions {
+		predictions[i] += e.BaseScore
+	}
 }
 
 // PredictRegression predicts float number for regression task using ensemble model interface.
+// The base value is added to the prediction on top of the ensemble base score.
 func (e *Ensemble) PredictRegression(features mat.SparseMatrix, baseVal float64) (mat.Matrix, error) {
 	if e.NumClasses() == 0 {
 		return mat.Matrix{}, fmt.Errorf("0 class please check your model")
@@ -32,14 +140,10 @@ func (e *Ensemble) PredictRegression(features mat.SparseMatrix, baseVal float64)
 
 	results := mat.Matrix{Vectors: make([]*mat.Vector, len(features.Vectors))}
 	for i, row := range features.Vectors {
-		pred, err := e.PredictInner(row)
+		pred, err := e.predictInner(row)
 		if err != nil {
 			return mat.Matrix{}, err
 		}
-		if len(pred) != e.NumClasses() {
-			return mat.Matrix{}, fmt.Errorf("number of predicted value (%d) must match number of classes (%d)",
-				len(pred), e.NumClasses())
-		}
 		if e.Type() != protobuf.ActivateType_RAW {
 			return mat.Matrix{}, fmt.Errorf("regression model must have raw activation")
 		}
@@ -61,14 +165,10 @@ func (e *Ensemble) PredictProba(features mat.SparseMatrix) (mat.Matrix, error) {
 
 	results := mat.Matrix{Vectors: make([]*mat.Vector, len(features.Vectors))}
 	for i, row := range features.Vectors {
-		pred, err := e.PredictInner(row)
+		pred, err := e.predictInner(row)
 		if err != nil {
 			return mat.Matrix{}, err
 		}
-		if len(pred) != e.NumClasses() {
-			return mat.Matrix{}, fmt.Errorf("number of predicted value (%d) must match number of classes (%d)",
-				len(pred), e.NumClasses())
-		}
 		pred, err = e.Transform(pred)
 		if err != nil {
 			return mat.Matrix{}, err
@@ -86,14 +186,10 @@ func (e *Ensemble) Predict(features mat.SparseMatrix) (mat.Matrix, error) {
 	}
 	results := mat.Matrix{Vectors: make([]*mat.Vector, len(features.Vectors))}
 	for i, row := range features.Vectors {
-		pred, err := e.PredictInner(row)
+		pred, err := e.predictInner(row)
 		if err != nil {
 			return mat.Matrix{}, err
 		}
-		if len(pred) != e.NumClasses() {
-			return mat.Matrix{}, fmt.Errorf("number of predicted value (%d) must match number of classes (%d)",
-				len(pred), e.NumClasses())
-		}
 		if len(pred) == 0 {
 			return mat.Matrix{}, fmt.Errorf("empty inner prediction")
 		}
@@ -116,7 +212,421 @@ func (e *Ensemble) Predict(features mat.SparseMatrix) (mat.Matrix, error) {
 	return results, nil
 }
 
+// PredictRow predicts transformed scores for a single dense feature vector using ensemble model interface.
+// The result has one score per class, for binary classification and regression it has only 1 element. Features
+// past the number of features of the model are ignored.
+func (e *Ensemble) PredictRow(features mat.Vector) (mat.Vector, error) {
+	if e.NumClasses() == 0 {
+		return mat.Vector{}, fmt.Errorf("0 class please check your model")
+	}
+	pred := make(mat.Vector, e.NumClasses())
+	if err := e.PredictInto(features, pred); err != nil {
+		return mat.Vector{}, err
+	}
+	return pred, nil
+}
+
+// PredictClass predicts the class of a single dense feature vector along with the transformed scores it is picked
+// from. For multiclass models the class is the index of the highest probability, for binary classification models it
+// is 1 when the probability is at least 0.5 and 0 otherwise.
+func (e *Ensemble) PredictClass(features mat.Vector) (int, mat.Vector, error) {
+	pred, err := e.PredictRow(features)
+	if err != nil {
+		return 0, mat.Vector{}, err
+	}
+	if e.NumClasses() == 1 {
+		if pred[0] >= 0.5 {
+			return 1, pred, nil
+		}
+		return 0, pred, nil
+	}
+	idx, err := mat.GetVectorMaxIdx(&pred)
+	if err != nil {
+		return 0, mat.Vector{}, err
+	}
+	return idx, pred, nil
+}
+
+// NumOutputGroups returns the number of scores predicted per row, which is the length out must have at least for
+// PredictInto. Activations transform the raw prediction of every output group into one score, so it is the number of
+// classes of the model, or of quantiles for a multiple quantile regression model, and 1 for binary classification and
+// regression. multi:softmax and multi:softprob models both predict one probability per class.
+func (e *Ensemble) NumOutputGroups() int {
+	return e.NumClasses()
+}
+
+// PredictInto is like PredictRow but writes the scores into the first number of classes elements of out, which
+// lets callers reuse one buffer across calls. It does not allocate with the built-in activations.
+func (e *Ensemble) PredictInto(features mat.Vector, out mat.Vector) error {
+	n := e.NumClasses()
+	if n == 0 {
+		return fmt.Errorf("0 class please check your model")
+	}
+	if len(out) < n {
+		return fmt.Errorf("output length (%d) must be at least number of classes (%d)", len(out), n)
+	}
+	out = out[:n]
+	if err := e.predictInnerDense(features, out); err != nil {
+		return err
+	}
+	p, err := e.Transform(out)
+	if err != nil {
+		return err
+	}
+	if len(p) != n {
+		return fmt.Errorf("number of transformed value (%d) must match number of classes (%d)", len(p), n)
+	}
+	if &p[0] != &out[0] {
+		// activation returned a new vector.
+		copy(out, p)
+	}
+	return nil
+}
+
+// PredictSparse predicts transformed scores for a single sparse feature vector using ensemble model interface.
+// Features absent from the vector are treated as missing values.
+func (e *Ensemble) PredictSparse(features mat.SparseVector) (mat.Vector, error) {
+	if e.NumClasses() == 0 {
+		return mat.Vector{}, fmt.Errorf("0 class please check your model")
+	}
+	pred, err := e.predictInner(features)
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	return e.Transform(pred)
+}
+
+// PredictByName predicts transformed scores for a single feature vector keyed by feature name, for example decoded
+// from a json request. Names are translated to feature indices through the feature map of the model, features absent
+// from the map are missing values. It returns an error wrapping ErrFeatureNotFound for a name unknown to the feature
+// map, and an error if the model has no feature map.
+func (e *Ensemble) PredictByName(features map[string]float64) (mat.Vector, error) {
+	indexer, ok := e.EnsembleBase.(featureIndexer)
+	if !ok || indexer.FeatureIndices() == nil {
+		return mat.Vector{}, fmt.Errorf("model is not loaded with a feature map")
+	}
+	indices := indexer.FeatureIndices()
+	sparse := make(mat.SparseVector, len(features))
+	for name, v := range features {
+		idx, ok := indices[name]
+		if !ok {
+			return mat.Vector{}, fmt.Errorf("%w %s in feature map", ErrFeatureNotFound, name)
+		}
+		sparse[idx] = v
+	}
+	return e.PredictSparse(sparse)
+}
+
+// PredictJSON predicts transformed scores for a single dense feature vector and writes them to w as a json object
+// mapping each class label to its score, for example {"setosa":0.01,"versicolor":0.97,"virginica":0.02} followed by
+// a new line. labels name the classes in class order, keys keep that order.
+func (e *Ensemble) PredictJSON(features mat.Vector, labels []string, w io.Writer) error {
+	if len(labels) != e.NumClasses() {
+		return fmt.Errorf("%w: %d labels for %d classes", ErrClassMismatch, len(labels), e.NumClasses())
+	}
+	seen := make(map[string]struct{}, len(labels))
+	for _, label := range labels {
+		if _, ok := seen[label]; ok {
+			return fmt.Errorf("duplicate label %s", label)
+		}
+		seen[label] = struct{}{}
+	}
+	pred, err := e.PredictRow(features)
+	if err != nil {
+		return err
+	}
+
+	out := []byte{'{'}
+	for c, label := range labels {
+		if math.IsNaN(pred[c]) || math.IsInf(pred[c], 0) {
+			return fmt.Errorf("score of class %s is not finite: %f", label, pred[c])
+		}
+		if c > 0 {
+			out = append(out, ',')
+		}
+		key, err := json.Marshal(label)
+		if err != nil {
+			return err
+		}
+		out = append(out, key...)
+		out = append(out, ':')
+		out = strconv.AppendFloat(out, pred[c], 'g', -1, 64)
+	}
+	out = append(out, '}', '\n')
+	_, err = w.Write(out)
+	return err
+}
+
+// PredictMargin predicts raw margins, the sum of leaf values per class plus base score, for a single dense feature vector without
+// applying the activation. Passing the result to Transform gives the same scores as PredictRow.
+func (e *Ensemble) PredictMargin(features mat.Vector) (mat.Vector, error) {
+	if e.NumClasses() == 0 {
+		return mat.Vector{}, fmt.Errorf("0 class please check your model")
+	}
+	pred := make(mat.Vector, e.NumClasses())
+	if err := e.predictInnerDense(features, pred); err != nil {
+		return mat.Vector{}, err
+	}
+	return pred, nil
+}
+
+// PredictClassMargins predicts the raw margin of every class of a multiclass model for a single dense feature vector,
+// before softmax, which helps to see how close the other classes were when the model predicts the wrong class.
+// Tree ensembles add tree i to class (i/numParallelTree)%numClasses, so with one tree per round tree i contributes to
+// class i%numClasses. The margins include the base score and their argmax is the predicted class.
+func (e *Ensemble) PredictClassMargins(features mat.Vector) (mat.Vector, error) {
+	if e.NumClasses() < 2 {
+		return mat.Vector{}, fmt.Errorf("class margins need a multiclass model, got %d class", e.NumClasses())
+	}
+	return e.PredictMargin(features)
+}
+
+// PredictBatch predicts transformed scores for every row of a dense matrix using ensemble model interface.
+// Every row must have at least the number of features of the model, extra trailing features are ignored.
+func (e *Ensemble) PredictBatch(features mat.Matrix) (mat.Matrix, error) {
+	if e.NumClasses() == 0 {
+		return mat.Matrix{}, fmt.Errorf("0 class please check your model")
+	}
+
+	results := mat.Matrix{Vectors: make([]*mat.Vector, len(features.Vectors))}
+	if err := e.predictRows(features.Vectors, results.Vectors, 0); err != nil {
+		return mat.Matrix{}, err
+	}
+	return results, nil
+}
+
+// ctxCheckRows is the number of rows predicted between two checks of the context by PredictBatchCtx.
+const ctxCheckRows = 1024
+
+// PredictBatchCtx is like PredictBatch but checks ctx every 1024 rows, it returns the error of ctx if it is done
+// before all rows are predicted.
+func (e *Ensemble) PredictBatchCtx(ctx context.Context, features mat.Matrix) (mat.Matrix, error) {
+	if e.NumClasses() == 0 {
+		return mat.Matrix{}, fmt.Errorf("0 class please check your model")
+	}
+
+	nRows := len(features.Vectors)
+	results := mat.Matrix{Vectors: make([]*mat.Vector, nRows)}
+	for start := 0; start < nRows; start += ctxCheckRows {
+		if err := ctx.Err(); err != nil {
+			return mat.Matrix{}, err
+		}
+		end := start + ctxCheckRows
+		if end > nRows {
+			end = nRows
+		}
+		if err := e.predictRows(features.Vectors[start:end], results.Vectors[start:end], start); err != nil {
+			return mat.Matrix{}, err
+		}
+	}
+	return results, nil
+}
+
+// PredictMatrix predicts transformed scores for every row of a dense matrix, which must have at least the number of
+// features of the model as columns, extra trailing columns are ignored. Scores of all rows share one backing slice.
+func (e *Ensemble) PredictMatrix(features mat.DenseMatrix) (mat.Matrix, error) {
+	n := e.NumClasses()
+	if n == 0 {
+		return mat.Matrix{}, fmt.Errorf("0 class please check your model")
+	}
+	if features.Cols < e.NumFeatures() {
+		return mat.Matrix{}, fmt.Errorf("matrix has %d features, model expects at least %d features", features.Cols,
+			e.NumFeatures())
+	}
+	if len(features.Values) != features.Rows*features.Cols {
+		return mat.Matrix{}, fmt.Errorf("matrix shape %dx%d needs %d values, got %d", features.Rows, features.Cols,
+			features.Rows*features.Cols, len(features.Values))
+	}
+	scores := make([]float64, features.Rows*n)
+	rows := make([]mat.Vector, features.Rows)
+	results := mat.Matrix{Vectors: make([]*mat.Vector, features.Rows)}
+	for i := range rows {
+		rows[i] = scores[i*n : (i+1)*n : (i+1)*n]
+		if err := e.PredictInto(features.Row(i), rows[i]); err != nil {
+			return mat.Matrix{}, fmt.Errorf("row %d: %w", i, err)
+		}
+		results.Vectors[i] = &rows[i]
+	}
+	return results, nil
+}
+
+// ValidateAgainst predicts transformed scores for every row of inputs and compares them to the expected scores, for
+// example the output of predict of DMLC XGBoost on the same rows. It returns an error describing the first score
+// differing by more than tol and the number of rows with such a score, nil if all scores match.
+func (e *Ensemble) ValidateAgainst(inputs mat.Matrix, expected mat.Matrix, tol float64) error {
+	if len(inputs.Vectors) != len(expected.Vectors) {
+		return fmt.Errorf("%d input rows for %d expected rows", len(inputs.Vectors), len(expected.Vectors))
+	}
+	if e.NumClasses() == 0 {
+		return fmt.Errorf("0 class please check your model")
+	}
+	pred := make(mat.Vector, e.NumClasses())
+	first := ""
+	mismatches := 0
+	for i, row := range inputs.Vectors {
+		if len(*expected.Vectors[i]) != len(pred) {
+			return fmt.Errorf("row %d: expected %d scores, model predicts %d", i, len(*expected.Vectors[i]),
+				len(pred))
+		}
+		if err := e.PredictInto(*row, pred); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		for c, v := range pred {
+			want := (*expected.Vectors[i])[c]
+			if math.Abs(v-want) <= tol {
+				continue
+			}
+			if mismatches == 0 {
+				first = fmt.Sprintf("row %d class %d: predicted %g, expected %g with tolerance %g", i, c, v, want, tol)
+			}
+			mismatches++
+			break
+		}
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d of %d rows mismatch, first at %s", mismatches, len(inputs.Vectors), first)
+	}
+	return nil
+}
+
+// PredictBatchParallel predicts transformed scores for every row of a dense matrix like PredictBatch, rows are
+// split across workers goroutines. If workers is 0 or smaller, the number of CPUs is used.
+func (e *Ensemble) PredictBatchParallel(features mat.Matrix, workers int) (mat.Matrix, error) {
+	if e.NumClasses() == 0 {
+		return mat.Matrix{}, fmt.Errorf("0 class please check your model")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	nRows := len(features.Vectors)
+	results := mat.Matrix{Vectors: make([]*mat.Vector, nRows)}
+	chunkSize := (nRows + workers - 1) / workers
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for w := 0; w < workers; w++ {
+		start := w * chunkSize
+		if start >= nRows {
+			break
+		}
+		end := start + chunkSize
+		if end > nRows {
+			end = nRows
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			errs[w] = e.predictRows(features.Vectors[start:end], results.Vectors[start:end], start)
+		}(w, start, end)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return mat.Matrix{}, err
+		}
+	}
+	return results, nil
+}
+
+// predictRows predicts transformed scores of dense rows into results with a single scratch buffer, offset is the
+// index of the first row used in error messages.
+func (e *Ensemble) predictRows(rows []*mat.Vector, results []*mat.Vector, offset int) error {
+	scratch := make(mat.Vector, e.NumClasses())
+	for i, row := range rows {
+		if len(*row) < e.NumFeatures() {
+			return fmt.Errorf("row %d has %d features, model expects at least %d features",
+				offset+i, len(*row), e.NumFeatures())
+		}
+		if err := e.predictInnerDense(*row, scratch); err != nil {
+			return fmt.Errorf("row %d: %w", offset+i, err)
+		}
+		p, err := e.Transform(scratch)
+		if err != nil {
+			return err
+		}
+		pred := make(mat.Vector, len(p))
+		copy(pred, p)
+		results[i] = &pred
+	}
+	return nil
+}
+
 // Name returns ensemble model name.
 func (e *Ensemble) Name() string {
 	return e.EnsembleBase.Name()
 }
+
+// cloner is implemented by base models that can deep copy themselves.
+type cloner interface {
+	Clone() EnsembleBase
+}
+
+// Clone returns a deep copy of the model, so changing the activation, the base score or the trees of the copy does
+// not affect the original. The base model must implement `Clone() EnsembleBase`, which tree ensembles loaded by this
+// library do.
+func (e *Ensemble) Clone() (*Ensemble, error) {
+	c, ok := e.EnsembleBase.(cloner)
+	if !ok {
+		return nil, fmt.Errorf("%s model cannot be cloned", e.Name())
+	}
+	return &Ensemble{EnsembleBase: c.Clone(), Activation: e.Activation, BaseScore: e.BaseScore, missing: e.missing,
+		hasMissing: e.hasMissing}, nil
+}
+
+// summarizer is implemented by base models describing their structure, for example tree counts and depths.
+type summarizer interface {
+	Summary() string
+}
+
+// Summary returns a one line human readable description of the model, handy to log after loading.
+func (e *Ensemble) Summary() string {
+	activationName := "none"
+	if e.Activation != nil {
+		activationName = e.Activation.Name()
+	}
+	summary := fmt.Sprintf("%s: classes=%d features=%d activation=%s", e.Name(), e.NumClasses(), e.NumFeatures(),
+		activationName)
+	if s, ok := e.EnsembleBase.(summarizer); ok {
+		summary += " " + s.Summary()
+	}
+	return summary
+}
+
+// memoryEstimator is implemented by base models estimating the memory they hold.
+type memoryEstimator interface {
+	ApproxMemoryBytes() int
+}
+
+// ApproxMemoryBytes estimates the memory held by the model in bytes, mostly the nodes of its trees, to plan how many
+// models fit in a process. It is not exact and is 0 if the base model does not implement `ApproxMemoryBytes() int`.
+func (e *Ensemble) ApproxMemoryBytes() int {
+	m, ok := e.EnsembleBase.(memoryEstimator)
+	if !ok {
+		return 0
+	}
+	return m.ApproxMemoryBytes()
+}
+
+// FeatureNames returns the feature names of the model indexed by feature index, read from the feature map or from
+// the feature names recorded in a save_model json. Indices without name are empty. It is nil if the model has no
+// feature names.
+func (e *Ensemble) FeatureNames() []string {
+	indexer, ok := e.EnsembleBase.(featureIndexer)
+	if !ok || indexer.FeatureIndices() == nil {
+		return nil
+	}
+	indices := indexer.FeatureIndices()
+	numNames := 0
+	for _, idx := range indices {
+		if idx+1 > numNames {
+			numNames = idx + 1
+		}
+	}
+	names := make([]string, numNames)
+	for name, idx := range indices {
+		if idx >= 0 {
+			names[idx] = name
+		}
+	}
+	return names
+}
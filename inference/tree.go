@@ -0,0 +1,514 @@
+package inference
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// TreeEnsemble contains interface of a tree based model. Ensemble methods inspecting trees require the base model
+// to implement it.
+type TreeEnsemble interface {
+	NumTrees() int
+	NumParallelTree() int
+	Objective() string
+	QuantileAlphas() []float64
+	TweedieVariancePower() float64
+	PredictInnerDenseLimit(features mat.Vector, predictions mat.Vector, ntreeLimit int) error
+	PredictLeafIndices(features mat.Vector) ([]int, error)
+	PredictPerTree(features mat.Vector) (mat.Vector, error)
+	DecisionPath(features mat.Vector) ([][]PathStep, error)
+	PredictContribs(features mat.Vector) (mat.Vector, error)
+	PredictContribsBatch(features mat.Matrix) (mat.Matrix, error)
+	PredictContribsApprox(features mat.Vector) (mat.Vector, error)
+	PredictInteractions(features mat.Vector) (mat.Matrix, error)
+	FeatureImportanceWeight() map[int]int
+	FeatureImportanceWeightByName() (map[string]int, error)
+	FeatureImportanceGain() map[int]float64
+	DumpJSON(w io.Writer) error
+	ToDOT(treeIndex int, w io.Writer) error
+	FeatureTypes() map[int]string
+	TreeStats() []TreeStat
+	TreeFillRatio(treeIndex int) (float64, error)
+	Tree(treeIndex int) (TreeView, error)
+	LeafValue(treeIndex, nodeID int) (float64, error)
+	PruneByGain(minGain float64) (int, error)
+	Remap(indexMapping map[int]int) error
+}
+
+// TreeView gives read only access to the nodes of a tree for custom traversal. Nodes are addressed by node id, the
+// root is node 0 and Yes, No and Missing return child node ids. Getters of node ids without node, which XGBoost
+// leaves in pruned trees, return zero values.
+type TreeView interface {
+	// NumNodes returns the largest node id plus 1.
+	NumNodes() int
+	// HasNode reports whether there is a node with this id.
+	HasNode(node int) bool
+	IsLeaf(node int) bool
+	// IsCategorical reports whether a split node sends values in Categories to Yes and the others to No, instead of
+	// comparing with Threshold.
+	IsCategorical(node int) bool
+	Feature(node int) int
+	// Threshold returns the split condition, values smaller than it go to Yes and the others go to No.
+	Threshold(node int) float64
+	Categories(node int) []int
+	Yes(node int) int
+	No(node int) int
+	// Missing returns the child taken by missing values.
+	Missing(node int) int
+	LeafValue(node int) float64
+}
+
+// Direction is the branch taken at a split node.
+type Direction int
+
+const (
+	// DirectionLeft is the yes branch, taken by values smaller than the threshold or in the categories of a
+	// categorical split.
+	DirectionLeft Direction = iota
+	// DirectionRight is the no branch, taken by the other values.
+	DirectionRight
+	// DirectionMissing is the branch taken by missing values, which is either the yes or the no branch.
+	DirectionMissing
+)
+
+// String returns the name of the direction.
+func (d Direction) String() string {
+	switch d {
+	case DirectionLeft:
+		return "left"
+	case DirectionRight:
+		return "right"
+	case DirectionMissing:
+		return "missing"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// PathStep is a split node on the decision path of a feature vector through a tree.
+type PathStep struct {
+	NodeID  int
+	Feature int
+	// Threshold is the split condition, it is 0 for categorical splits.
+	Threshold float64
+	// Categorical reports whether the split tests the categories of the feature instead of comparing with Threshold.
+	Categorical bool
+	// Value is the feature value of the input, NaN if it is missing.
+	Value     float64
+	Direction Direction
+	// Next is the node id of the child taken, the last step of a path leads to the leaf.
+	Next int
+}
+
+// NodeView describes a node of a tree visited by Walk.
+type NodeView struct {
+	ID int
+	// Depth is the number of splits between the root and the node, the root has depth 0.
+	Depth  int
+	IsLeaf bool
+	// Feature, Threshold, IsCategorical, Categories, Yes, No and Missing describe a split node like TreeView does,
+	// they are zero values for leaves.
+	Feature       int
+	Threshold     float64
+	IsCategorical bool
+	Categories    []int
+	Yes           int
+	No            int
+	Missing       int
+	// LeafValue is the value of a leaf, 0 for split nodes.
+	LeafValue float64
+}
+
+// TreeStat holds size statistics of a tree.
+type TreeStat struct {
+	// Index is the position of the tree in the model.
+	Index int
+	// Nodes is the number of split and leaf nodes.
+	Nodes int
+	// Leaves is the number of leaf nodes.
+	Leaves int
+	// Depth is the length of the longest path from the root to a leaf, a single leaf tree has depth 0.
+	Depth int
+}
+
+// treeEnsemble returns the base model as a tree ensemble.
+func (e *Ensemble) treeEnsemble() (TreeEnsemble, error) {
+	t, ok := e.EnsembleBase.(TreeEnsemble)
+	if !ok {
+		return nil, fmt.Errorf("%s model is not a tree ensemble", e.Name())
+	}
+	return t, nil
+}
+
+// NumTrees returns number of trees of the base model, it is 0 if the base model is not a tree ensemble.
+func (e *Ensemble) NumTrees() int {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return 0
+	}
+	return t.NumTrees()
+}
+
+// NumParallelTree returns number of parallel trees per boosting round of the base model, it is 0 if the base model
+// is not a tree ensemble.
+func (e *Ensemble) NumParallelTree() int {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return 0
+	}
+	return t.NumParallelTree()
+}
+
+// Objective returns the objective name of the base model, it is empty if the model does not record it.
+func (e *Ensemble) Objective() string {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return ""
+	}
+	return t.Objective()
+}
+
+// QuantileAlphas returns the quantile predicted by every output of a quantile regression model with
+// `reg:quantileerror` objective, the scores of PredictRow and PredictBatch follow the same order. It is nil for other
+// models and base models that are not tree ensembles.
+func (e *Ensemble) QuantileAlphas() []float64 {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil
+	}
+	return t.QuantileAlphas()
+}
+
+// TweedieVariancePower returns the `tweedie_variance_power` of a model with `reg:tweedie` objective, read from
+// save_model json. It only matters for training, predictions are the exponential of the margin whatever the power.
+// It is 0 for other models, models not recording it and base models that are not tree ensembles.
+func (e *Ensemble) TweedieVariancePower() float64 {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return 0
+	}
+	return t.TweedieVariancePower()
+}
+
+// PredictWithLimit predicts transformed scores for a single dense feature vector using only the first
+// ntreeLimit*numClasses trees, for example the best iteration of early stopping. Like `ntree_limit` of DMLC XGBoost
+// the limit counts parallel trees, so it is the number of rounds times NumParallelTree. All trees are used if
+// ntreeLimit is 0.
+func (e *Ensemble) PredictWithLimit(features mat.Vector, ntreeLimit int) (mat.Vector, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	if err := e.checkDenseFeatures(features); err != nil {
+		return mat.Vector{}, err
+	}
+	pred := make(mat.Vector, e.NumClasses())
+	if err := t.PredictInnerDenseLimit(e.denseMissing(features), pred, ntreeLimit); err != nil {
+		return mat.Vector{}, err
+	}
+	e.addBaseScore(pred)
+	return e.Transform(pred)
+}
+
+// PredictLeafIndices returns the leaf node id each tree routes a dense feature vector to, in tree order.
+func (e *Ensemble) PredictLeafIndices(features mat.Vector) ([]int, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkDenseFeatures(features); err != nil {
+		return nil, err
+	}
+	return t.PredictLeafIndices(e.denseMissing(features))
+}
+
+// PredictPerTree returns the contribution of every tree to the raw margin of a dense feature vector, in tree order:
+// its leaf value times its tree weight, divided by the number of parallel trees. Summing the contributions of the trees
+// of every class, tree i belonging to class (i/numParallelTree)%numClasses, and adding the base score gives the
+// margins of PredictMargin.
+func (e *Ensemble) PredictPerTree(features mat.Vector) (mat.Vector, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	if err := e.checkDenseFeatures(features); err != nil {
+		return mat.Vector{}, err
+	}
+	return t.PredictPerTree(e.denseMissing(features))
+}
+
+// DecisionPath returns the split nodes a dense feature vector goes through in every tree, in tree order, which
+// explains a prediction as rules like "feature 2 >= 0.5". Unlike PredictLeafIndices it records the feature value and
+// the branch taken at every split. The path of a single leaf tree is empty.
+func (e *Ensemble) DecisionPath(features mat.Vector) ([][]PathStep, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkDenseFeatures(features); err != nil {
+		return nil, err
+	}
+	return t.DecisionPath(e.denseMissing(features))
+}
+
+// PredictContribs returns the SHAP value of every feature for a dense feature vector using TreeSHAP, the same as
+// `pred_contribs=True` of DMLC XGBoost. For every class it holds one contribution per feature followed by the bias
+// term, classes are laid out one after another. Contributions of a class sum to its margin.
+func (e *Ensemble) PredictContribs(features mat.Vector) (mat.Vector, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	contribs, err := t.PredictContribs(e.denseMissing(features))
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	e.addBaseScoreToBias(contribs)
+	return contribs, nil
+}
+
+// PredictContribsBatch returns the SHAP values of every row of a dense matrix, each laid out like PredictContribs.
+// It is faster than calling PredictContribs on every row since the expected values of the trees are computed once for
+// the whole batch.
+func (e *Ensemble) PredictContribsBatch(features mat.Matrix) (mat.Matrix, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return mat.Matrix{}, err
+	}
+	if e.hasMissing {
+		rows := mat.Matrix{Vectors: make([]*mat.Vector, len(features.Vectors))}
+		for i, row := range features.Vectors {
+			r := e.denseMissing(*row)
+			rows.Vectors[i] = &r
+		}
+		features = rows
+	}
+	contribs, err := t.PredictContribsBatch(features)
+	if err != nil {
+		return mat.Matrix{}, err
+	}
+	for _, row := range contribs.Vectors {
+		e.addBaseScoreToBias(*row)
+	}
+	return contribs, nil
+}
+
+// PredictContribsApprox is like PredictContribs but uses the cheaper Saabas method, the same as
+// `pred_contribs=True, approx_contribs=True` of DMLC XGBoost. Every split on the decision path attributes the change
+// of the expected value to its feature, so unlike SHAP values the result depends on the order of the splits.
+func (e *Ensemble) PredictContribsApprox(features mat.Vector) (mat.Vector, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	contribs, err := t.PredictContribsApprox(e.denseMissing(features))
+	if err != nil {
+		return mat.Vector{}, err
+	}
+	e.addBaseScoreToBias(contribs)
+	return contribs, nil
+}
+
+// PredictInteractions returns the SHAP interaction values of a dense feature vector, the same as
+// `pred_interactions=True` of DMLC XGBoost. Every class has a square block of numFeatures+1 rows of numFeatures+1
+// values, the last row and column being the bias term, and blocks are laid out one after another. Row i of a block
+// holds the interactions of feature i with every feature, the diagonal holding its main effect, so the row sums are
+// the SHAP values of PredictContribs and the values of a block sum to the margin of the class. It needs node covers
+// and computes TreeSHAP twice per feature, which makes it much slower than PredictContribs.
+func (e *Ensemble) PredictInteractions(features mat.Vector) (mat.Matrix, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return mat.Matrix{}, err
+	}
+	interactions, err := t.PredictInteractions(e.denseMissing(features))
+	if err != nil {
+		return mat.Matrix{}, err
+	}
+	stride := e.NumFeatures() + 1
+	for i := stride - 1; i < len(interactions.Vectors); i += stride {
+		(*interactions.Vectors[i])[stride-1] += e.BaseScore
+	}
+	return interactions, nil
+}
+
+// addBaseScoreToBias adds base score to the bias term of every class of feature contributions.
+func (e *Ensemble) addBaseScoreToBias(contribs mat.Vector) {
+	stride := e.NumFeatures() + 1
+	for i := stride - 1; i < len(contribs); i += stride {
+		contribs[i] += e.BaseScore
+	}
+}
+
+// FeatureImportanceWeight returns the number of split nodes using each feature index across all trees.
+func (e *Ensemble) FeatureImportanceWeight() (map[int]int, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	return t.FeatureImportanceWeight(), nil
+}
+
+// FeatureImportanceWeightByName returns the number of split nodes using each feature name across all trees.
+// It requires the model to be loaded with a feature map.
+func (e *Ensemble) FeatureImportanceWeightByName() (map[string]int, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	return t.FeatureImportanceWeightByName()
+}
+
+// FeatureImportanceGain returns the total gain of split nodes using each feature index across all trees.
+// The result is empty if the model is dumped without statistics.
+func (e *Ensemble) FeatureImportanceGain() (map[int]float64, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	return t.FeatureImportanceGain(), nil
+}
+
+// DumpJSON writes the tree ensemble in the same json format as DMLC XGBoost dump_model API.
+func (e *Ensemble) DumpJSON(w io.Writer) error {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return err
+	}
+	return t.DumpJSON(w)
+}
+
+// ToDOT writes the tree at treeIndex in Graphviz DOT format.
+func (e *Ensemble) ToDOT(treeIndex int, w io.Writer) error {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return err
+	}
+	return t.ToDOT(treeIndex, w)
+}
+
+// FeatureTypes returns the type of every feature index read from the feature map.
+func (e *Ensemble) FeatureTypes() (map[int]string, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	return t.FeatureTypes(), nil
+}
+
+// TreeStats returns size statistics of every tree, in tree order.
+func (e *Ensemble) TreeStats() ([]TreeStat, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	return t.TreeStats(), nil
+}
+
+// TreeFillRatio returns the number of nodes of the tree at treeIndex over the number of nodes of a full binary tree
+// of the same depth. It is 1 for a full balanced tree and gets lower as the tree is lopsided, which helps tuning
+// max_depth.
+func (e *Ensemble) TreeFillRatio(treeIndex int) (float64, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return 0, err
+	}
+	return t.TreeFillRatio(treeIndex)
+}
+
+// Tree returns a read only view of the tree at treeIndex.
+func (e *Ensemble) Tree(treeIndex int) (TreeView, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return nil, err
+	}
+	return t.Tree(treeIndex)
+}
+
+// Walk calls fn for every node of every tree, in tree order. Nodes of a tree are visited depth first from the root,
+// a split node before its Yes then its No subtree. The walk stops as soon as fn returns false.
+func (e *Ensemble) Walk(fn func(treeIndex int, node NodeView) bool) error {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < t.NumTrees(); i++ {
+		tree, err := t.Tree(i)
+		if err != nil {
+			return err
+		}
+		if !walkTree(tree, i, fn) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// walkTree calls fn for every node of a tree depth first, it returns false if fn stopped the walk.
+func walkTree(tree TreeView, treeIndex int, fn func(treeIndex int, node NodeView) bool) bool {
+	type visit struct {
+		id    int
+		depth int
+	}
+	stack := []visit{{0, 0}}
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !tree.HasNode(v.id) {
+			continue
+		}
+		node := NodeView{ID: v.id, Depth: v.depth, IsLeaf: tree.IsLeaf(v.id)}
+		if node.IsLeaf {
+			node.LeafValue = tree.LeafValue(v.id)
+		} else {
+			node.Feature = tree.Feature(v.id)
+			node.IsCategorical = tree.IsCategorical(v.id)
+			if node.IsCategorical {
+				node.Categories = tree.Categories(v.id)
+			} else {
+				node.Threshold = tree.Threshold(v.id)
+			}
+			node.Yes, node.No, node.Missing = tree.Yes(v.id), tree.No(v.id), tree.Missing(v.id)
+			// the yes subtree is on top of the stack.
+			stack = append(stack, visit{node.No, v.depth + 1}, visit{node.Yes, v.depth + 1})
+		}
+		if !fn(treeIndex, node) {
+			return false
+		}
+	}
+	return true
+}
+
+// LeafValue returns the value of leaf nodeID of the tree at treeIndex, for example a leaf returned by
+// PredictLeafIndices. It returns an error if the node is a split node.
+func (e *Ensemble) LeafValue(treeIndex, nodeID int) (float64, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return 0, err
+	}
+	return t.LeafValue(treeIndex, nodeID)
+}
+
+// PruneByGain shrinks the trees in place by collapsing split nodes whose gain is below minGain and whose children
+// are leaves into a leaf, valued by the cover weighted average of the children. It returns the number of removed
+// nodes. Use Clone first to keep the original model.
+func (e *Ensemble) PruneByGain(minGain float64) (int, error) {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return 0, err
+	}
+	return t.PruneByGain(minGain)
+}
+
+// Remap moves the model to another input layout by rewriting the feature index of every split through indexMapping,
+// keyed by the feature index the model is trained with and valued by the feature index in the new layout. Every feature
+// used by a split must be mapped and no two features can be mapped to the same index, the model is left unchanged
+// otherwise. Use Clone first to keep the original model.
+func (e *Ensemble) Remap(indexMapping map[int]int) error {
+	t, err := e.treeEnsemble()
+	if err != nil {
+		return err
+	}
+	return t.Remap(indexMapping)
+}
@@ -0,0 +1,133 @@
+package inference
+
+import (
+	"fmt"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// weightedEnsemble is a base model blending the transformed scores of several models by weight.
+type weightedEnsemble struct {
+	models      []*Ensemble
+	weights     []float64
+	numClasses  int
+	numFeatures int
+}
+
+// NewWeightedEnsemble returns a model predicting the weighted average of the transformed scores of models, the
+// weights are normalized to sum to 1. All models must have the same number of classes. The returned model has raw
+// activation since scores of the models are already transformed.
+func NewWeightedEnsemble(models []*Ensemble, weights []float64) (*Ensemble, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no model to blend")
+	}
+	if len(models) != len(weights) {
+		return nil, fmt.Errorf("number of models (%d) must match number of weights (%d)", len(models), len(weights))
+	}
+	w := &weightedEnsemble{models: models, weights: make([]float64, len(weights))}
+	total := 0.0
+	for i, model := range models {
+		if model == nil {
+			return nil, fmt.Errorf("model %d is nil", i)
+		}
+		if weights[i] < 0 {
+			return nil, fmt.Errorf("weight of model %d cannot be negative: %f", i, weights[i])
+		}
+		if i == 0 {
+			w.numClasses = model.NumClasses()
+		} else if model.NumClasses() != w.numClasses {
+			return nil, fmt.Errorf("%w: model %d has %d classes, expected %d classes", ErrClassMismatch, i,
+				model.NumClasses(), w.numClasses)
+		}
+		if model.NumFeatures() > w.numFeatures {
+			w.numFeatures = model.NumFeatures()
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("weights sum to 0")
+	}
+	for i, weight := range weights {
+		w.weights[i] = weight / total
+	}
+	return &Ensemble{EnsembleBase: w, Activation: &activation.Raw{}}, nil
+}
+
+// PredictInner returns the weighted average of the transformed scores of a sparse feature vector.
+func (w *weightedEnsemble) PredictInner(features mat.SparseVector) (mat.Vector, error) {
+	pred := make(mat.Vector, w.numClasses)
+	for i, model := range w.models {
+		p, err := model.PredictSparse(features)
+		if err != nil {
+			return mat.Vector{}, fmt.Errorf("error while predicting with model %d: %w", i, err)
+		}
+		for c := range pred {
+			pred[c] += w.weights[i] * p[c]
+		}
+	}
+	return pred, nil
+}
+
+// PredictInnerDense writes the weighted average of the transformed scores of a dense feature vector into
+// predictions.
+func (w *weightedEnsemble) PredictInnerDense(features mat.Vector, predictions mat.Vector) error {
+	if len(predictions) != w.numClasses {
+		return fmt.Errorf("predictions length (%d) must match number of classes (%d)", len(predictions), w.numClasses)
+	}
+	for c := range predictions {
+		predictions[c] = 0
+	}
+	for i, model := range w.models {
+		p, err := model.PredictRow(features)
+		if err != nil {
+			return fmt.Errorf("error while predicting with model %d: %w", i, err)
+		}
+		for c := range predictions {
+			predictions[c] += w.weights[i] * p[c]
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the blended model, blended models that cannot be cloned are shared.
+func (w *weightedEnsemble) Clone() EnsembleBase {
+	c := &weightedEnsemble{
+		models:      make([]*Ensemble, len(w.models)),
+		weights:     append([]float64(nil), w.weights...),
+		numClasses:  w.numClasses,
+		numFeatures: w.numFeatures,
+	}
+	for i, model := range w.models {
+		clone, err := model.Clone()
+		if err != nil {
+			clone = model
+		}
+		c.models[i] = clone
+	}
+	return c
+}
+
+// Name returns name of the blended model.
+func (w *weightedEnsemble) Name() string {
+	return "weighted"
+}
+
+// NumClasses returns number of classes shared by the blended models.
+func (w *weightedEnsemble) NumClasses() int {
+	return w.numClasses
+}
+
+// NumFeatures returns the largest number of features of the blended models.
+func (w *weightedEnsemble) NumFeatures() int {
+	return w.numFeatures
+}
+
+// ApproxMemoryBytes estimates the memory held by the blended models.
+func (w *weightedEnsemble) ApproxMemoryBytes() int {
+	size := 8 * len(w.weights)
+	for _, model := range w.models {
+		size += model.ApproxMemoryBytes()
+	}
+	return size
+}
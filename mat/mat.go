@@ -28,11 +28,36 @@ type Matrix struct {
 	Vectors []*Vector
 }
 
+// DenseMatrix is a row major matrix backed by a single slice, row i is Values[i*Cols:(i+1)*Cols]. It has better
+// memory locality than Matrix for large inputs.
+type DenseMatrix struct {
+	Rows   int
+	Cols   int
+	Values []float64
+}
+
+// NewDenseMatrix returns a dense matrix of rows by cols values in row major order.
+func NewDenseMatrix(rows, cols int, values []float64) (DenseMatrix, error) {
+	if rows < 0 || cols < 0 {
+		return DenseMatrix{}, fmt.Errorf("invalid matrix shape %dx%d", rows, cols)
+	}
+	if len(values) != rows*cols {
+		return DenseMatrix{}, fmt.Errorf("matrix shape %dx%d needs %d values, got %d", rows, cols, rows*cols,
+			len(values))
+	}
+	return DenseMatrix{Rows: rows, Cols: cols, Values: values}, nil
+}
+
+// Row returns row i of the matrix, it shares memory with the matrix.
+func (m DenseMatrix) Row(i int) Vector {
+	return m.Values[i*m.Cols : (i+1)*m.Cols : (i+1)*m.Cols]
+}
+
 // ReadLibsvmFileToSparseMatrix reads libsvm file into sparse matrix.
 func ReadLibsvmFileToSparseMatrix(fileName string) (SparseMatrix, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
-		return SparseMatrix{}, fmt.Errorf("unable to open %s: %s", fileName, err)
+		return SparseMatrix{}, fmt.Errorf("unable to open %s: %w", fileName, err)
 	}
 	defer file.Close()
 
@@ -68,11 +93,11 @@ func ReadLibsvmFileToSparseMatrix(fileName string) (SparseMatrix, error) {
 			}
 			colIdx, err := strconv.ParseUint(pair[0], 10, 32)
 			if err != nil {
-				return SparseMatrix{}, fmt.Errorf("cannot parse to int %s: %s", pair[0], err)
+				return SparseMatrix{}, fmt.Errorf("cannot parse to int %s: %w", pair[0], err)
 			}
 			val, err := strconv.ParseFloat(pair[1], 64)
 			if err != nil {
-				return SparseMatrix{}, fmt.Errorf("cannot parse to float %s: %s", pair[1], err)
+				return SparseMatrix{}, fmt.Errorf("cannot parse to float %s: %w", pair[1], err)
 			}
 			vec[int(colIdx)] = val
 		}
@@ -85,7 +110,7 @@ func ReadLibsvmFileToSparseMatrix(fileName string) (SparseMatrix, error) {
 func ReadCSVFileToDenseMatrix(fileName string, delimiter string, defaultVal float64) (Matrix, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
-		return Matrix{}, fmt.Errorf("unable to open %s: %s", fileName, err)
+		return Matrix{}, fmt.Errorf("unable to open %s: %w", fileName, err)
 	}
 	defer file.Close()
 
@@ -112,7 +137,7 @@ func ReadCSVFileToDenseMatrix(fileName string, delimiter string, defaultVal floa
 			} else {
 				v, err := strconv.ParseFloat(tokens[i], 64)
 				if err != nil {
-					return Matrix{}, fmt.Errorf("cannot convert to float %s: %s", tokens[i], err)
+					return Matrix{}, fmt.Errorf("cannot convert to float %s: %w", tokens[i], err)
 				}
 				val = v
 			}
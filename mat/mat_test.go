@@ -20,3 +20,13 @@ func TestReadCSVFileToDenseMatrix(t *testing.T) {
 	assert.Check(t, len(m.Vectors) != 0)
 	assert.Equal(t, len(*m.Vectors[0]), 3)
 }
+
+func TestNewDenseMatrix(t *testing.T) {
+	m, err := NewDenseMatrix(2, 3, []float64{1, 2, 3, 4, 5, 6})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, m.Row(1), Vector{4, 5, 6})
+	assert.Equal(t, cap(m.Row(0)), 3)
+
+	_, err = NewDenseMatrix(2, 3, []float64{1, 2, 3})
+	assert.ErrorContains(t, err, "matrix shape 2x3 needs 6 values, got 3")
+}
@@ -27,6 +27,7 @@ const (
 	ActivateType_RAW      ActivateType = 1
 	ActivateType_LOGISTIC ActivateType = 2
 	ActivateType_SOFTMAX  ActivateType = 3
+	ActivateType_EXP      ActivateType = 4
 )
 
 var ActivateType_name = map[int32]string{
@@ -34,6 +35,7 @@ var ActivateType_name = map[int32]string{
 	1: "RAW",
 	2: "LOGISTIC",
 	3: "SOFTMAX",
+	4: "EXP",
 }
 
 var ActivateType_value = map[string]int32{
@@ -41,6 +43,7 @@ var ActivateType_value = map[string]int32{
 	"RAW":      1,
 	"LOGISTIC": 2,
 	"SOFTMAX":  3,
+	"EXP":      4,
 }
 
 func (x ActivateType) String() string {
@@ -58,14 +61,14 @@ func init() {
 func init() { proto.RegisterFile("activation.proto", fileDescriptor_baec3c6aeacf77ef) }
 
 var fileDescriptor_baec3c6aeacf77ef = []byte{
-	// 132 bytes of a gzipped FileDescriptorProto
+	// 136 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x48, 0x4c, 0x2e, 0xc9,
 	0x2c, 0x4b, 0x2c, 0xc9, 0xcc, 0xcf, 0xd3, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x00, 0x53,
-	0x49, 0xa5, 0x69, 0x5a, 0xf6, 0x5c, 0x3c, 0x8e, 0x10, 0xd9, 0xd4, 0x90, 0xca, 0x82, 0x54, 0x21,
+	0x49, 0xa5, 0x69, 0x5a, 0x1e, 0x5c, 0x3c, 0x8e, 0x10, 0xd9, 0xd4, 0x90, 0xca, 0x82, 0x54, 0x21,
 	0x6e, 0x2e, 0xf6, 0x50, 0x3f, 0x6f, 0x3f, 0xff, 0x70, 0x3f, 0x01, 0x06, 0x21, 0x76, 0x2e, 0xe6,
 	0x20, 0xc7, 0x70, 0x01, 0x46, 0x21, 0x1e, 0x2e, 0x0e, 0x1f, 0x7f, 0x77, 0xcf, 0xe0, 0x10, 0x4f,
-	0x67, 0x01, 0x26, 0x90, 0x9a, 0x60, 0x7f, 0xb7, 0x10, 0x5f, 0xc7, 0x08, 0x01, 0x66, 0x27, 0x81,
-	0x13, 0x8f, 0xe4, 0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c, 0xf0, 0x48, 0x8e, 0x71, 0xc6, 0x63, 0x39,
-	0x86, 0x24, 0x36, 0xb0, 0xe1, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0xf4, 0xa2, 0x98, 0xe6,
-	0x77, 0x00, 0x00, 0x00,
+	0x67, 0x01, 0x26, 0x90, 0x9a, 0x60, 0x7f, 0xb7, 0x10, 0x5f, 0xc7, 0x08, 0x01, 0x66, 0x90, 0x1a,
+	0xd7, 0x88, 0x00, 0x01, 0x16, 0x27, 0x81, 0x13, 0x8f, 0xe4, 0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c,
+	0xf0, 0x48, 0x8e, 0x71, 0xc6, 0x63, 0x39, 0x86, 0x24, 0x36, 0xb0, 0x2d, 0xc6, 0x80, 0x01, 0x00,
+	0xdb, 0x99, 0xdb, 0xa7, 0x80, 0x00, 0x00, 0x00,
 }
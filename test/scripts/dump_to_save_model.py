@@ -0,0 +1,111 @@
+"""Convert a dump_model json file to the json layout written by the save_model API.
+
+The trees of dump_model and save_model are the same, this script lets the tests check the save_model loader
+against the predictions already recorded for the dumped models.
+"""
+import argparse
+import json
+
+
+def convert_tree(tree_id, root):
+    nodes = {}
+    stack = [root]
+    while stack:
+        node = stack.pop()
+        nodes[node['nodeid']] = node
+        stack.extend(node.get('children', []))
+
+    num_nodes = max(nodes) + 1
+    parents = [2147483647] * num_nodes
+    left_children = [-1] * num_nodes
+    right_children = [-1] * num_nodes
+    split_indices = [0] * num_nodes
+    split_conditions = [0.0] * num_nodes
+    default_left = [False] * num_nodes
+    for nid, node in nodes.items():
+        if 'leaf' in node:
+            split_conditions[nid] = node['leaf']
+            continue
+        left_children[nid] = node['yes']
+        right_children[nid] = node['no']
+        parents[node['yes']] = nid
+        parents[node['no']] = nid
+        split_indices[nid] = int(node['split'][1:])
+        split_conditions[nid] = node['split_condition']
+        default_left[nid] = node['missing'] == node['yes']
+
+    return {
+        'base_weights': split_conditions,
+        'categories': [],
+        'categories_nodes': [],
+        'categories_segments': [],
+        'categories_sizes': [],
+        'default_left': default_left,
+        'id': tree_id,
+        'left_children': left_children,
+        'loss_changes': [0.0] * num_nodes,
+        'parents': parents,
+        'right_children': right_children,
+        'split_conditions': split_conditions,
+        'split_indices': split_indices,
+        'split_type': [0] * num_nodes,
+        'sum_hessian': [0.0] * num_nodes,
+        'tree_param': {
+            'num_deleted': '0',
+            'num_feature': '0',
+            'num_nodes': str(num_nodes),
+            'size_leaf_vector': '0',
+        },
+    }
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument('dump')
+    parser.add_argument('output')
+    parser.add_argument('--objective', required=True)
+    parser.add_argument('--num-class', type=int, default=0)
+    parser.add_argument('--num-feature', type=int, required=True)
+    parser.add_argument('--base-score', type=float, default=0.5)
+    args = parser.parse_args()
+
+    with open(args.dump) as f:
+        dump = json.load(f)
+    num_groups = max(args.num_class, 1)
+    trees = [convert_tree(i, tree) for i, tree in enumerate(dump)]
+    for tree in trees:
+        tree['tree_param']['num_feature'] = str(args.num_feature)
+
+    model = {
+        'learner': {
+            'attributes': {},
+            'feature_names': [],
+            'feature_types': [],
+            'gradient_booster': {
+                'model': {
+                    'gbtree_model_param': {
+                        'num_parallel_tree': '1',
+                        'num_trees': str(len(trees)),
+                    },
+                    'tree_info': [i % num_groups for i in range(len(trees))],
+                    'trees': trees,
+                },
+                'name': 'gbtree',
+            },
+            'learner_model_param': {
+                'base_score': '%E' % args.base_score,
+                'num_class': str(args.num_class),
+                'num_feature': str(args.num_feature),
+            },
+            'objective': {
+                'name': args.objective,
+            },
+        },
+        'version': [1, 2, 0],
+    }
+    with open(args.output, 'w') as f:
+        json.dump(model, f)
+
+
+if __name__ == '__main__':
+    main()
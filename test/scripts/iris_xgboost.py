@@ -13,8 +13,9 @@ X, y = datasets.load_iris(return_X_y=True)
 X_train, X_test, y_train, y_test = train_test_split(X, y, test_size=0.2, random_state=0)
 
 dtrain = xgb.DMatrix(X_train, label=y_train)
+# base_score is the default, it is set as dump_model json does not record it.
 param = {'max_depth': 4, 'eta': 1, 'objective': 'multi:softmax', 'nthread': 4,
-         'eval_metric': 'auc', 'num_class': 3}
+         'eval_metric': 'auc', 'num_class': 3, 'base_score': 0.5}
 
 num_round = 10
 bst = xgb.train(param, dtrain, num_round)
@@ -25,3 +26,23 @@ np.savetxt('../data/iris_xgboost_true_prediction.txt', y_pred, delimiter='\t')
 np.savetxt('../data/iris_xgboost_true_prediction_proba.txt', y_pred_proba, delimiter='\t')
 dump_svmlight_file(X_test, y_test, '../data/iris_test.libsvm')
 bst.dump_model('../data/iris_xgboost_dump.json', dump_format='json')
+
+# SHAP values need node covers, which are only dumped with statistics. Contributions of a row are laid out class
+# after class, each class holding one value per feature followed by the bias term.
+bst.dump_model('../data/iris_xgboost_dump_stats.json', dump_format='json', with_stats=True)
+contribs = bst.predict(xgb.DMatrix(X_test), pred_contribs=True)
+np.savetxt('../data/iris_xgboost_true_contribs.txt', contribs.reshape(len(X_test), -1), delimiter='\t')
+
+# SHAP interaction values of a row are a square block per class of one row per feature followed by the bias row,
+# rows of all classes of all rows are written one after another.
+interactions = bst.predict(xgb.DMatrix(X_test), pred_interactions=True)
+np.savetxt('../data/iris_xgboost_true_interactions.txt', interactions.reshape(-1, X_test.shape[1] + 1),
+           delimiter='\t')
+
+# Random forest style booster, the 4 parallel trees of each class in a boosting round are averaged.
+forest_param = {'max_depth': 4, 'eta': 1, 'objective': 'multi:softprob', 'nthread': 4, 'num_class': 3,
+                'num_parallel_tree': 4, 'subsample': 0.8, 'colsample_bynode': 0.8, 'seed': 0}
+forest = xgb.train(forest_param, dtrain, 3)
+np.savetxt('../data/iris_xgboost_parallel_tree_true_prediction_proba.txt', forest.predict(xgb.DMatrix(X_test)),
+           delimiter='\t')
+forest.save_model('../data/iris_xgboost_parallel_tree_save_model.json')
@@ -1,14 +1,28 @@
 package xgboost
 
 import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/Elvenson/xgboost-go/inference"
 	"github.com/Elvenson/xgboost-go/mat"
 )
 
 type xgbEnsemble struct {
-	Trees      []*xgbTree
-	name       string
-	numClasses int
-	numFeat    int
+	Trees           []*xgbTree
+	flatTrees       []*flatTree
+	name            string
+	objective       string
+	numClasses      int
+	numParallelTree int
+	numFeat         int
+	featureNames    map[int]string
+	featureIndices  map[string]int
+	featureTypes    map[int]string
+	treeWeights     []float64
+	quantileAlphas  []float64
+	variancePower   float64
 }
 
 // Name returns name of ensemble model.
@@ -16,24 +30,432 @@ func (e *xgbEnsemble) Name() string {
 	return e.name
 }
 
-// NumClasses returns number of features for this ensemble model.
+// Objective returns the objective name of this ensemble model, it is empty if the model does not record it like
+// dump_model json.
+func (e *xgbEnsemble) Objective() string {
+	return e.objective
+}
+
+// QuantileAlphas returns the quantile predicted by every output of a quantile regression model, in output order. It
+// is nil for other models.
+func (e *xgbEnsemble) QuantileAlphas() []float64 {
+	return e.quantileAlphas
+}
+
+// NumClasses returns number of classes for this ensemble model.
 func (e *xgbEnsemble) NumClasses() int {
 	return e.numClasses
 }
 
-// PredictInner returns prediction of this ensemble model.
+// NumTrees returns number of trees for this ensemble model.
+func (e *xgbEnsemble) NumTrees() int {
+	return len(e.Trees)
+}
+
+// NumParallelTree returns number of parallel trees per boosting round of this ensemble model.
+func (e *xgbEnsemble) NumParallelTree() int {
+	return e.numParallelTree
+}
+
+// NumFeatures returns number of features this ensemble model expects.
+func (e *xgbEnsemble) NumFeatures() int {
+	return e.numFeat
+}
+
+// TweedieVariancePower returns the variance power of a tweedie regression model, 0 for other models.
+func (e *xgbEnsemble) TweedieVariancePower() float64 {
+	return e.variancePower
+}
+
+// Summary returns a one line description of the trees of this ensemble model.
+func (e *xgbEnsemble) Summary() string {
+	nodes, maxDepth, totalDepth := 0, 0, 0
+	for _, stat := range e.TreeStats() {
+		nodes += stat.Nodes
+		totalDepth += stat.Depth
+		if stat.Depth > maxDepth {
+			maxDepth = stat.Depth
+		}
+	}
+	avgDepth := 0.0
+	if len(e.Trees) > 0 {
+		avgDepth = float64(totalDepth) / float64(len(e.Trees))
+	}
+	return fmt.Sprintf("trees=%d nodes=%d avg_depth=%.2f max_depth=%d", len(e.Trees), nodes, avgDepth, maxDepth)
+}
+
+// ApproxMemoryBytes estimates the memory held by this ensemble model, dominated by the nodes of its trees and their
+// flat copies.
+func (e *xgbEnsemble) ApproxMemoryBytes() int {
+	size := int(unsafe.Sizeof(*e)) + (len(e.Trees)+len(e.flatTrees))*pointerBytes
+	for _, tree := range e.Trees {
+		size += tree.approxMemoryBytes()
+	}
+	for _, tree := range e.flatTrees {
+		size += tree.approxMemoryBytes()
+	}
+	for name := range e.featureIndices {
+		// names are shared by both feature maps.
+		size += len(name) + 2*mapEntryBytes
+	}
+	if e.featureTypes != nil {
+		size += mapBytes + len(e.featureTypes)*mapEntryBytes
+	}
+	size += 8 * (len(e.treeWeights) + len(e.quantileAlphas))
+	return size
+}
+
+// TreeStats returns node count, leaf count and depth of every tree.
+func (e *xgbEnsemble) TreeStats() []inference.TreeStat {
+	stats := make([]inference.TreeStat, len(e.Trees))
+	for i, tree := range e.Trees {
+		stats[i] = inference.TreeStat{Index: i, Depth: tree.depth(0)}
+		for _, node := range tree.nodes {
+			if node == nil {
+				continue
+			}
+			stats[i].Nodes++
+			if node.Flags&isLeaf > 0 {
+				stats[i].Leaves++
+			}
+		}
+	}
+	return stats
+}
+
+// TreeFillRatio returns the number of nodes of the tree at treeIndex divided by the number of nodes of a full
+// binary tree of the same depth, which is the array capacity a tree of this depth needs.
+func (e *xgbEnsemble) TreeFillRatio(treeIndex int) (float64, error) {
+	if treeIndex < 0 || treeIndex >= len(e.Trees) {
+		return 0, fmt.Errorf("tree index %d out of range [0, %d)", treeIndex, len(e.Trees))
+	}
+	tree := e.Trees[treeIndex]
+	nodes := 0
+	for _, node := range tree.nodes {
+		if node != nil {
+			nodes++
+		}
+	}
+	capacity := 1<<uint(tree.depth(0)+1) - 1
+	return float64(nodes) / float64(capacity), nil
+}
+
+// Tree returns a read only view of the tree at treeIndex.
+func (e *xgbEnsemble) Tree(treeIndex int) (inference.TreeView, error) {
+	if treeIndex < 0 || treeIndex >= len(e.Trees) {
+		return nil, fmt.Errorf("tree index %d out of range [0, %d)", treeIndex, len(e.Trees))
+	}
+	return treeView{tree: e.Trees[treeIndex]}, nil
+}
+
+// LeafValue returns the value of leaf nodeID of the tree at treeIndex.
+func (e *xgbEnsemble) LeafValue(treeIndex, nodeID int) (float64, error) {
+	if treeIndex < 0 || treeIndex >= len(e.Trees) {
+		return 0, fmt.Errorf("tree index %d out of range [0, %d)", treeIndex, len(e.Trees))
+	}
+	nodes := e.Trees[treeIndex].nodes
+	if nodeID < 0 || nodeID >= len(nodes) || nodes[nodeID] == nil {
+		return 0, fmt.Errorf("cannot find node %d in tree %d", nodeID, treeIndex)
+	}
+	if nodes[nodeID].Flags&isLeaf == 0 {
+		return 0, fmt.Errorf("node %d in tree %d is an internal node, not a leaf", nodeID, treeIndex)
+	}
+	return nodes[nodeID].LeafValues, nil
+}
+
+// Clone returns a deep copy of the trees and nodes of this ensemble model, feature maps are never modified after
+// loading and are shared.
+func (e *xgbEnsemble) Clone() inference.EnsembleBase {
+	c := *e
+	c.Trees = make([]*xgbTree, len(e.Trees))
+	for i, tree := range e.Trees {
+		c.Trees[i] = tree.clone()
+	}
+	c.flatten()
+	return &c
+}
+
+// PruneByGain collapses split nodes whose gain is below minGain into leaves valued by the cover weighted average of
+// their children and returns the number of removed nodes. It needs node gains and covers, which DMLC XGBoost dumps
+// with `with_stats=True`.
+func (e *xgbEnsemble) PruneByGain(minGain float64) (int, error) {
+	for i, tree := range e.Trees {
+		if err := tree.checkCovers(); err != nil {
+			return 0, fmt.Errorf("cannot prune %d tree: %w", i, err)
+		}
+	}
+	removed := 0
+	for _, tree := range e.Trees {
+		removed += tree.prune(0, minGain)
+	}
+	e.flatten()
+	return removed, nil
+}
+
+// Remap rewrites the feature index of every split node through indexMapping, from the feature index the model is
+// trained with to the feature index of the new input layout. The mapping must cover every feature used by a split and
+// map distinct features to distinct indices. Feature names and types follow their feature, those of features absent
+// from the mapping are dropped, and the number of features becomes the largest mapped index plus 1.
+func (e *xgbEnsemble) Remap(indexMapping map[int]int) error {
+	mapped := make(map[int]int, len(indexMapping))
+	numFeat := 0
+	for from, to := range indexMapping {
+		if to < 0 || to > maxIndex {
+			return fmt.Errorf("feature %d is mapped to out of range index %d", from, to)
+		}
+		if other, ok := mapped[to]; ok {
+			return fmt.Errorf("features %d and %d are both mapped to index %d", other, from, to)
+		}
+		mapped[to] = from
+		if to >= numFeat {
+			numFeat = to + 1
+		}
+	}
+	for i, tree := range e.Trees {
+		for _, node := range tree.nodes {
+			if node == nil || node.Flags&isLeaf > 0 {
+				continue
+			}
+			if _, ok := indexMapping[node.Feature]; !ok {
+				return fmt.Errorf("feature %d used by node %d of tree %d is not in the mapping", node.Feature,
+					node.NodeID, i)
+			}
+		}
+	}
+
+	for _, tree := range e.Trees {
+		for _, node := range tree.nodes {
+			if node == nil || node.Flags&isLeaf > 0 {
+				continue
+			}
+			node.Feature = indexMapping[node.Feature]
+		}
+	}
+	// feature maps are shared with clones, so they are rebuilt instead of modified.
+	if e.featureNames != nil {
+		names := make(map[int]string, len(e.featureNames))
+		indices := make(map[string]int, len(e.featureNames))
+		for from, name := range e.featureNames {
+			if to, ok := indexMapping[from]; ok {
+				names[to] = name
+				indices[name] = to
+			}
+		}
+		e.featureNames, e.featureIndices = names, indices
+	}
+	if e.featureTypes != nil {
+		types := make(map[int]string, len(e.featureTypes))
+		for from, typ := range e.featureTypes {
+			if to, ok := indexMapping[from]; ok {
+				types[to] = typ
+			}
+		}
+		e.featureTypes = types
+	}
+	e.numFeat = numFeat
+	e.flatten()
+	return nil
+}
+
+// flatten builds the flat representation of the trees used for dense prediction, it must be called whenever trees
+// are modified.
+func (e *xgbEnsemble) flatten() {
+	e.flatTrees = make([]*flatTree, len(e.Trees))
+	for i, tree := range e.Trees {
+		e.flatTrees[i] = newFlatTree(tree)
+	}
+}
+
+// treeClass returns the class a tree contributes to. Trees are laid out per boosting round, within a round
+// every class has numParallelTree consecutive trees.
+func (e *xgbEnsemble) treeClass(treeIndex int) int {
+	return (treeIndex / e.numParallelTree) % e.numClasses
+}
+
+// treeWeight returns the weight scaling the output of a tree, it is 1 unless the model is a DART booster.
+func (e *xgbEnsemble) treeWeight(treeIndex int) float64 {
+	if e.treeWeights == nil {
+		return 1
+	}
+	return e.treeWeights[treeIndex]
+}
+
+// FeatureTypes returns the type of every feature index read from the feature map, for example `q` for quantitative
+// or `i` for indicator features, or from the feature types recorded in a save_model json. It is nil if the model is
+// loaded without either.
+func (e *xgbEnsemble) FeatureTypes() map[int]string {
+	return e.featureTypes
+}
+
+// PredictInner returns prediction of this ensemble model. Parallel trees of a boosting round are averaged, trees
+// of DART boosters are scaled by their weight.
 func (e *xgbEnsemble) PredictInner(features mat.SparseVector) (mat.Vector, error) {
-	// number of trees for 1 class.
 	pred := make([]float64, e.numClasses)
-	numTreesPerClass := len(e.Trees) / e.numClasses
-	for i := 0; i < e.numClasses; i++ {
-		for k := 0; k < numTreesPerClass; k++ {
-			p, err := e.Trees[k*e.numClasses+i].predict(features)
-			if err != nil {
-				return mat.Vector{}, nil
-			}
-			pred[i] += p
+	for i, tree := range e.Trees {
+		p, err := tree.predict(features)
+		if err != nil {
+			return mat.Vector{}, err
 		}
+		pred[e.treeClass(i)] += e.treeWeight(i) * p
 	}
+	e.averageParallelTrees(pred)
 	return pred, nil
 }
+
+// PredictInnerDense accumulates raw prediction of this ensemble model for a dense feature vector into predictions,
+// which must have the length of the number of classes. Parallel trees of a boosting round are averaged.
+func (e *xgbEnsemble) PredictInnerDense(features mat.Vector, predictions mat.Vector) error {
+	return e.PredictInnerDenseLimit(features, predictions, 0)
+}
+
+// PredictInnerDenseLimit is like PredictInnerDense but only uses the first ntreeLimit*numClasses trees, all trees
+// are used if ntreeLimit is 0.
+func (e *xgbEnsemble) PredictInnerDenseLimit(features mat.Vector, predictions mat.Vector, ntreeLimit int) error {
+	if len(predictions) != e.numClasses {
+		return fmt.Errorf("predictions length (%d) must match number of classes (%d)", len(predictions), e.numClasses)
+	}
+	if ntreeLimit < 0 {
+		return fmt.Errorf("tree limit cannot be smaller than 0: %d", ntreeLimit)
+	}
+	nTrees := len(e.flatTrees)
+	if ntreeLimit > 0 && ntreeLimit*e.numClasses < nTrees {
+		nTrees = ntreeLimit * e.numClasses
+	}
+	for i := range predictions {
+		predictions[i] = 0
+	}
+	for i, tree := range e.flatTrees[:nTrees] {
+		p, err := tree.predictDense(features)
+		if err != nil {
+			return err
+		}
+		predictions[e.treeClass(i)] += e.treeWeight(i) * p
+	}
+	e.averageParallelTrees(predictions)
+	return nil
+}
+
+// averageParallelTrees turns the sum of parallel trees of every boosting round into their average.
+func (e *xgbEnsemble) averageParallelTrees(predictions mat.Vector) {
+	if e.numParallelTree == 1 {
+		return
+	}
+	for i := range predictions {
+		predictions[i] /= float64(e.numParallelTree)
+	}
+}
+
+// PredictLeafIndices returns the leaf node id each tree routes a dense feature vector to, in tree order.
+func (e *xgbEnsemble) PredictLeafIndices(features mat.Vector) ([]int, error) {
+	leaves := make([]int, len(e.Trees))
+	for i, tree := range e.Trees {
+		node, err := tree.leafDense(features)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = node.NodeID
+	}
+	return leaves, nil
+}
+
+// PredictPerTree returns the weighted leaf value of every tree for a dense feature vector, in tree order, parallel
+// trees are averaged so the values of the trees of a class sum up to its raw prediction.
+func (e *xgbEnsemble) PredictPerTree(features mat.Vector) (mat.Vector, error) {
+	values := make(mat.Vector, len(e.flatTrees))
+	for i, tree := range e.flatTrees {
+		p, err := tree.predictDense(features)
+		if err != nil {
+			return mat.Vector{}, err
+		}
+		values[i] = e.treeWeight(i) * p / float64(e.numParallelTree)
+	}
+	return values, nil
+}
+
+// DecisionPath returns the split nodes each tree routes a dense feature vector through, in tree order.
+func (e *xgbEnsemble) DecisionPath(features mat.Vector) ([][]inference.PathStep, error) {
+	paths := make([][]inference.PathStep, len(e.Trees))
+	for i, tree := range e.Trees {
+		path := make([]inference.PathStep, 0)
+		idx := 0
+		for {
+			node := tree.nodes[idx]
+			if node == nil {
+				return nil, fmt.Errorf("nil node")
+			}
+			if node.Flags&isLeaf > 0 {
+				break
+			}
+			step := inference.PathStep{
+				NodeID:      node.NodeID,
+				Feature:     node.Feature,
+				Categorical: node.Flags&isCategorical > 0,
+				Value:       features[node.Feature],
+			}
+			if !step.Categorical {
+				step.Threshold = node.Threshold
+			}
+			step.Next = node.next(step.Value, true)
+			switch {
+			case math.IsNaN(step.Value):
+				step.Direction = inference.DirectionMissing
+			case step.Next == node.Yes:
+				step.Direction = inference.DirectionLeft
+			default:
+				step.Direction = inference.DirectionRight
+			}
+			path = append(path, step)
+			idx = step.Next
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// FeatureImportanceWeight returns the number of split nodes using each feature index across all trees.
+func (e *xgbEnsemble) FeatureImportanceWeight() map[int]int {
+	importance := make(map[int]int)
+	for _, tree := range e.Trees {
+		for _, node := range tree.nodes {
+			if node == nil || node.Flags&isLeaf > 0 {
+				continue
+			}
+			importance[node.Feature]++
+		}
+	}
+	return importance
+}
+
+// FeatureImportanceWeightByName returns the number of split nodes using each feature name across all trees.
+// It requires the model to be loaded with a feature map.
+func (e *xgbEnsemble) FeatureImportanceWeightByName() (map[string]int, error) {
+	if e.featureNames == nil {
+		return nil, fmt.Errorf("model is not loaded with a feature map")
+	}
+	importance := make(map[string]int)
+	for idx, count := range e.FeatureImportanceWeight() {
+		importance[e.featureNames[idx]] = count
+	}
+	return importance, nil
+}
+
+// FeatureIndices returns the feature indices by name of the feature map the model is loaded with, or of the feature
+// names recorded in a save_model json, nil if it has neither. The map must not be modified.
+func (e *xgbEnsemble) FeatureIndices() map[string]int {
+	return e.featureIndices
+}
+
+// FeatureImportanceGain returns the total gain of split nodes using each feature index across all trees.
+// The result is empty if the model is dumped without statistics.
+func (e *xgbEnsemble) FeatureImportanceGain() map[int]float64 {
+	importance := make(map[int]float64)
+	for _, tree := range e.Trees {
+		for _, node := range tree.nodes {
+			if node == nil || node.Flags&isLeaf > 0 || node.Gain == 0 {
+				continue
+			}
+			importance[node.Feature] += node.Gain
+		}
+	}
+	return importance
+}
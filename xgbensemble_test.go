@@ -1,12 +1,24 @@
 package xgboost
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"gotest.tools/assert"
 
 	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/inference"
 	"github.com/Elvenson/xgboost-go/mat"
+	"github.com/Elvenson/xgboost-go/protobuf"
 )
 
 func TestEnsemble_PredictBreastCancer(t *testing.T) {
@@ -82,6 +94,61 @@ func TestEnsemble_PredictBreastCancerFeatureMap(t *testing.T) {
 	assert.NilError(t, err)
 }
 
+func TestEnsemble_PredictByName(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump_fmap.json",
+		"test/data/breast_cancer_fmap.txt", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+	names := ensemble.FeatureNames()
+
+	for _, row := range input.Vectors {
+		named := make(map[string]float64, len(row))
+		for idx, v := range row {
+			named[names[idx]] = v
+		}
+		// absent names are missing values.
+		delete(named, "mean_radius")
+		delete(row, 0)
+
+		pred, err := ensemble.PredictByName(named)
+		assert.NilError(t, err)
+		expected, err := ensemble.PredictSparse(row)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualVectors(&pred, &expected, 0))
+	}
+
+	_, err = ensemble.PredictByName(map[string]float64{"mean_radius": 1, "radius": 2})
+	assert.Assert(t, errors.Is(err, inference.ErrFeatureNotFound), err)
+	assert.Error(t, err, "cannot find feature radius in feature map")
+	ensemble, err = LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	_, err = ensemble.PredictByName(map[string]float64{"f0": 1})
+	assert.Error(t, err, "model is not loaded with a feature map")
+}
+
+func TestEnsemble_PredictJSON(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	labels := []string{"setosa", "versicolor", "virginica"}
+	features := mat.Vector{5.8, 2.8, 5.1, 2.4}
+
+	var buf bytes.Buffer
+	assert.NilError(t, ensemble.PredictJSON(features, labels, &buf))
+	assert.Assert(t, strings.HasPrefix(buf.String(), `{"setosa":`), buf.String())
+	assert.Assert(t, strings.HasSuffix(buf.String(), "}\n"), buf.String())
+	var scores map[string]float64
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &scores))
+	pred, err := ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, scores, map[string]float64{"setosa": pred[0], "versicolor": pred[1], "virginica": pred[2]})
+
+	err = ensemble.PredictJSON(features, labels[:2], &buf)
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+	assert.Error(t, ensemble.PredictJSON(features, []string{"a", "b", "a"}, &buf), "duplicate label a")
+	assert.Error(t, ensemble.PredictJSON(mat.Vector{1}, labels, &buf), "expected at least 4 features, got 1")
+}
+
 func TestEnsemble_BreastCancerRegression(t *testing.T) {
 	modelPath := "test/data/breast_cancer_xgboost_dump_regression.json"
 	ensemble, err := LoadXGBoostFromJSON(modelPath,
@@ -151,3 +218,1601 @@ func TestEnsemble_Iris(t *testing.T) {
 	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001)
 	assert.NilError(t, err)
 }
+
+func TestEnsemble_PredictRowIris(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	inputPath := "test/data/iris_test.libsvm"
+	input, err := mat.ReadLibsvmFileToSparseMatrix(inputPath)
+	assert.NilError(t, err)
+
+	expectedProbPath := "test/data/iris_xgboost_true_prediction_proba.txt"
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix(expectedProbPath, "\t", 0.0)
+	assert.NilError(t, err)
+
+	for i, row := range input.Vectors {
+		features := mat.Vector{row[0], row[1], row[2], row[3]}
+		pred, err := ensemble.PredictRow(features)
+		assert.NilError(t, err)
+		assert.Equal(t, len(pred), 3)
+		err = mat.IsEqualVectors(&pred, expectedProb.Vectors[i], 0.0001)
+		assert.NilError(t, err)
+	}
+}
+
+func TestEnsemble_PredictClass(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	// a virginica sample of the test set.
+	class, prob, err := ensemble.PredictClass(mat.Vector{5.8, 2.8, 5.1, 2.4})
+	assert.NilError(t, err)
+	assert.Equal(t, class, 2)
+	assert.Equal(t, len(prob), 3)
+	assert.Assert(t, prob[2] > prob[0] && prob[2] > prob[1])
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	expected, err := mat.ReadCSVFileToDenseMatrix("test/data/iris_xgboost_true_prediction.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	for i, row := range input.Vectors {
+		class, _, err := ensemble.PredictClass(mat.Vector{row[0], row[1], row[2], row[3]})
+		assert.NilError(t, err)
+		assert.Equal(t, float64(class), (*expected.Vectors[i])[0])
+	}
+
+	binary, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	cancer, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+	for _, row := range cancer.Vectors[:20] {
+		features := make(mat.Vector, binary.NumFeatures())
+		for idx, v := range row {
+			features[idx] = v
+		}
+		class, prob, err := binary.PredictClass(features)
+		assert.NilError(t, err)
+		assert.Equal(t, len(prob), 1)
+		assert.Equal(t, class == 1, prob[0] >= 0.5)
+	}
+}
+
+func TestEnsemble_PredictBatchIris(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	inputPath := "test/data/iris_test.libsvm"
+	input, err := mat.ReadLibsvmFileToSparseMatrix(inputPath)
+	assert.NilError(t, err)
+
+	dense := mat.Matrix{Vectors: make([]*mat.Vector, len(input.Vectors))}
+	for i, row := range input.Vectors {
+		dense.Vectors[i] = &mat.Vector{row[0], row[1], row[2], row[3]}
+	}
+
+	expectedProbPath := "test/data/iris_xgboost_true_prediction_proba.txt"
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix(expectedProbPath, "\t", 0.0)
+	assert.NilError(t, err)
+
+	predictions, err := ensemble.PredictBatch(dense)
+	assert.NilError(t, err)
+
+	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001)
+	assert.NilError(t, err)
+
+	// wrong number of columns.
+	dense.Vectors[1] = &mat.Vector{1, 2, 3}
+	_, err = ensemble.PredictBatch(dense)
+	assert.ErrorContains(t, err, "row 1")
+}
+
+func TestEnsemble_BreastCancerActivationFromObjective(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump.json"
+	inputPath := "test/data/breast_cancer_test.libsvm"
+	input, err := mat.ReadLibsvmFileToSparseMatrix(inputPath)
+	assert.NilError(t, err)
+
+	expectedPredPath := "test/data/breast_cancer_xgboost_true_prediction.txt"
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix(expectedPredPath, "\t", 0.0)
+	assert.NilError(t, err)
+
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, activation.FromObjective("binary:logistic"))
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.Type(), protobuf.ActivateType_LOGISTIC)
+
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001)
+	assert.NilError(t, err)
+
+	// without objective the raw margins are returned.
+	ensemble, err = LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, activation.FromObjective(""))
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.Type(), protobuf.ActivateType_RAW)
+
+	predictions, err = ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	for i, pred := range predictions.Vectors {
+		prob := mat.Vector{1.0 / (1.0 + math.Exp(-(*pred)[0]))}
+		err = mat.IsEqualVectors(&prob, expectedProb.Vectors[i], 0.0001)
+		assert.NilError(t, err)
+	}
+}
+
+func TestEnsemble_PredictLeafIndicesIris(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	trees := ensemble.EnsembleBase.(*xgbEnsemble).Trees
+
+	leaves, err := ensemble.PredictLeafIndices(mat.Vector{5.8, 2.8, 5.1, 2.4})
+	assert.NilError(t, err)
+	assert.Equal(t, len(leaves), len(trees))
+	for i, leaf := range leaves {
+		node := trees[i].nodes[leaf]
+		assert.Assert(t, node != nil)
+		assert.Check(t, node.Flags&isLeaf > 0, "tree %d node %d is not a leaf", i, leaf)
+	}
+
+	_, err = ensemble.PredictLeafIndices(mat.Vector{5.8})
+	assert.Error(t, err, "expected at least 4 features, got 1")
+}
+
+func TestEnsemble_PredictPerTree(t *testing.T) {
+	weights := make([]float64, 30)
+	for i := range weights {
+		weights[i] = 1 + float64(i%4)/2
+	}
+	tests := []struct {
+		numClasses int
+		opts       []LoadOption
+	}{
+		{3, nil},
+		{3, []LoadOption{WithTreeWeights(weights)}},
+		// 10 rounds of 3 parallel trees.
+		{1, []LoadOption{WithNumParallelTree(3)}},
+	}
+	for _, tc := range tests {
+		ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", tc.numClasses, 0,
+			&activation.Raw{}, tc.opts...)
+		assert.NilError(t, err)
+		ensemble.BaseScore = 0.5
+
+		for _, row := range irisDenseInput(t).Vectors {
+			values, err := ensemble.PredictPerTree(*row)
+			assert.NilError(t, err)
+			assert.Equal(t, len(values), ensemble.NumTrees())
+
+			sums := make(mat.Vector, tc.numClasses)
+			for i, v := range values {
+				sums[(i/ensemble.NumParallelTree())%tc.numClasses] += v
+			}
+			for c := range sums {
+				sums[c] += ensemble.BaseScore
+			}
+			margin, err := ensemble.PredictMargin(*row)
+			assert.NilError(t, err)
+			assert.NilError(t, mat.IsEqualVectors(&sums, &margin, 1e-9))
+		}
+	}
+
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	_, err = ensemble.PredictPerTree(mat.Vector{1, 2})
+	assert.Error(t, err, "expected at least 4 features, got 2")
+}
+
+func TestEnsemble_DecisionPath(t *testing.T) {
+	stump := `{ "nodeid": 0, "leaf": 0.5 }`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+","+stump+"]"), "", 1, 0,
+		&activation.Raw{})
+	assert.NilError(t, err)
+
+	paths, err := ensemble.DecisionPath(mat.Vector{1.0, 0.7, 3.0})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, paths, [][]inference.PathStep{
+		{
+			{NodeID: 0, Feature: 2, Threshold: 2.5, Value: 3.0, Direction: inference.DirectionRight, Next: 2},
+			{NodeID: 2, Feature: 1, Threshold: 0.5, Value: 0.7, Direction: inference.DirectionRight, Next: 6},
+		},
+		{},
+	})
+
+	paths, err = ensemble.DecisionPath(mat.Vector{math.NaN(), 0.7, 1.0})
+	assert.NilError(t, err)
+	assert.Equal(t, paths[0][0].Direction, inference.DirectionLeft)
+	assert.Equal(t, paths[0][1].Direction, inference.DirectionMissing)
+	assert.Equal(t, paths[0][1].Direction.String(), "missing")
+	assert.Equal(t, paths[0][1].Next, 3)
+
+	_, err = ensemble.DecisionPath(mat.Vector{1.0})
+	assert.Error(t, err, "expected at least 3 features, got 1")
+
+	// paths lead to the leaves of PredictLeafIndices.
+	iris, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	features := mat.Vector{5.8, 2.8, 5.1, 2.4}
+	leaves, err := iris.PredictLeafIndices(features)
+	assert.NilError(t, err)
+	paths, err = iris.DecisionPath(features)
+	assert.NilError(t, err)
+	for i, path := range paths {
+		leaf := 0
+		if len(path) > 0 {
+			leaf = path[len(path)-1].Next
+		}
+		assert.Equal(t, leaf, leaves[i])
+	}
+}
+
+func TestEnsemble_LeafValue(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	features := mat.Vector{5.8, 2.8, 5.1, 2.4}
+
+	// leaf values of the reached leaves sum up to the margins.
+	leaves, err := ensemble.PredictLeafIndices(features)
+	assert.NilError(t, err)
+	margin := make(mat.Vector, 3)
+	for i, leaf := range leaves {
+		v, err := ensemble.LeafValue(i, leaf)
+		assert.NilError(t, err)
+		margin[i%3] += v
+	}
+	expected, err := ensemble.PredictMargin(features)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualVectors(&margin, &expected, 1e-12))
+
+	v, err := ensemble.LeafValue(0, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, v, 1.41818178)
+	_, err = ensemble.LeafValue(0, 0)
+	assert.ErrorContains(t, err, "node 0 in tree 0 is an internal node, not a leaf")
+	_, err = ensemble.LeafValue(0, 3)
+	assert.ErrorContains(t, err, "cannot find node 3 in tree 0")
+	_, err = ensemble.LeafValue(30, 0)
+	assert.ErrorContains(t, err, "tree index 30 out of range [0, 30)")
+}
+
+func TestEnsemble_ValidateAgainst(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	input := irisDenseInput(t)
+	expected, err := mat.ReadCSVFileToDenseMatrix("test/data/iris_xgboost_true_prediction_proba.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	assert.NilError(t, ensemble.ValidateAgainst(input, expected, 1e-4))
+
+	(*expected.Vectors[2])[1] = 0.5
+	(*expected.Vectors[4])[0] = 0.5
+	err = ensemble.ValidateAgainst(input, expected, 1e-4)
+	assert.ErrorContains(t, err, "2 of 30 rows mismatch, first at row 2 class 1: predicted 0.0120633")
+	assert.ErrorContains(t, err, "expected 0.5 with tolerance 0.0001")
+
+	err = ensemble.ValidateAgainst(mat.Matrix{Vectors: input.Vectors[:2]}, expected, 1e-4)
+	assert.Error(t, err, "2 input rows for 30 expected rows")
+	expected.Vectors[0] = &mat.Vector{1}
+	err = ensemble.ValidateAgainst(input, expected, 1e-4)
+	assert.Error(t, err, "row 0: expected 1 scores, model predicts 3")
+}
+
+func TestEnsemble_PredictClassMargins(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	for _, row := range irisDenseInput(t).Vectors {
+		margins, err := ensemble.PredictClassMargins(*row)
+		assert.NilError(t, err)
+		assert.Equal(t, len(margins), 3)
+		proba, err := ensemble.PredictRow(*row)
+		assert.NilError(t, err)
+
+		marginClass, err := mat.GetVectorMaxIdx(&margins)
+		assert.NilError(t, err)
+		probaClass, err := mat.GetVectorMaxIdx(&proba)
+		assert.NilError(t, err)
+		assert.Equal(t, marginClass, probaClass)
+	}
+
+	binary, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	_, err = binary.PredictClassMargins(make(mat.Vector, binary.NumFeatures()))
+	assert.Error(t, err, "class margins need a multiclass model, got 1 class")
+}
+
+func TestEnsemble_PredictMarginBreastCancer(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+
+	features := make(mat.Vector, ensemble.NumFeatures())
+	for i := range features {
+		features[i] = float64(i)
+	}
+	margin, err := ensemble.PredictMargin(features)
+	assert.NilError(t, err)
+	assert.Equal(t, len(margin), 1)
+
+	pred, err := ensemble.PredictRow(features)
+	assert.NilError(t, err)
+
+	transformed, err := ensemble.Transform(margin)
+	assert.NilError(t, err)
+	err = mat.IsEqualVectors(&transformed, &pred, 0.0000001)
+	assert.NilError(t, err)
+}
+
+func TestEnsemble_PredictRowMissingValue(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"),
+		"", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+
+	nan := math.NaN()
+	tests := []struct {
+		features mat.Vector
+		expected float64
+	}{
+		{mat.Vector{0, 0, 3}, 0.3},
+		{mat.Vector{0, nan, 3}, 0.4}, // missing goes to node 6.
+		{mat.Vector{2, 0, nan}, 0.2}, // missing goes to node 1.
+		{mat.Vector{nan, 0, nan}, 0.1},
+	}
+	for _, tc := range tests {
+		pred, err := ensemble.PredictRow(tc.features)
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], tc.expected, "features %v", tc.features)
+	}
+
+	// sparse input without the feature follows the same missing branch.
+	sparsePred, err := ensemble.PredictProba(mat.SparseMatrix{Vectors: []mat.SparseVector{{2: 3}}})
+	assert.NilError(t, err)
+	assert.Equal(t, (*sparsePred.Vectors[0])[0], 0.4)
+}
+
+func TestEnsemble_SetMissingValue(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"),
+		"", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+	assert.Assert(t, math.IsNaN(ensemble.MissingValue()))
+
+	features := mat.Vector{0, -999, 3}
+	pred, err := ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.3) // -999 < 0.5 goes to node 5.
+
+	ensemble.SetMissingValue(-999)
+	assert.Equal(t, ensemble.MissingValue(), -999.0)
+	pred, err = ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.4) // missing goes to node 6.
+	assert.DeepEqual(t, features, mat.Vector{0, -999, 3})
+	pred, err = ensemble.PredictSparse(mat.SparseVector{1: -999, 2: 3})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.4)
+	leaves, err := ensemble.PredictLeafIndices(features)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, leaves, []int{6})
+	// NaN is still missing.
+	pred, err = ensemble.PredictRow(mat.Vector{0, math.NaN(), 3})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.4)
+
+	clone, err := ensemble.Clone()
+	assert.NilError(t, err)
+	assert.Equal(t, clone.MissingValue(), -999.0)
+
+	ensemble.SetMissingValue(math.NaN())
+	pred, err = ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.3)
+
+	// missing features do not contribute to linear models.
+	linear, err := LoadXGBoostLinearFromReader(strings.NewReader(`[{"bias": [0.5], "weight": [1, 2]}]`), 1, nil)
+	assert.NilError(t, err)
+	linear.SetMissingValue(-1)
+	pred, err = linear.PredictRow(mat.Vector{-1, 3})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 6.5)
+}
+
+func TestEnsemble_PredictSparseIris(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+
+	for _, row := range input.Vectors {
+		sparse := mat.SparseVector{0: row[0], 2: row[2]}
+		dense := mat.Vector{row[0], math.NaN(), row[2], math.NaN()}
+		sparsePred, err := ensemble.PredictSparse(sparse)
+		assert.NilError(t, err)
+		densePred, err := ensemble.PredictRow(dense)
+		assert.NilError(t, err)
+		err = mat.IsEqualVectors(&sparsePred, &densePred, 0)
+		assert.NilError(t, err)
+	}
+}
+
+func TestEnsemble_BaseScore(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	margin, err := ensemble.PredictMargin(mat.Vector(make([]float64, ensemble.NumFeatures())))
+	assert.NilError(t, err)
+
+	ensemble.BaseScore = 0.5
+	biasedPredictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	biasedMargin, err := ensemble.PredictMargin(mat.Vector(make([]float64, ensemble.NumFeatures())))
+	assert.NilError(t, err)
+
+	assert.Check(t, mat.IsEqualMatrices(&predictions, &biasedPredictions, 0.0001) != nil)
+	assert.Equal(t, biasedMargin[0], margin[0]+0.5)
+}
+
+func TestEnsemble_FeatureImportanceWeight(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+","+twoLevelTreeJSON+"]"),
+		"", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+
+	importance, err := ensemble.FeatureImportanceWeight()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, importance, map[int]int{0: 2, 1: 2, 2: 2})
+
+	_, err = ensemble.FeatureImportanceWeightByName()
+	assert.ErrorContains(t, err, "feature map")
+
+	modelPath := "test/data/breast_cancer_xgboost_dump_fmap.json"
+	ensemble, err = LoadXGBoostFromJSON(modelPath,
+		"test/data/breast_cancer_fmap.txt", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	importance, err = ensemble.FeatureImportanceWeight()
+	assert.NilError(t, err)
+	importanceByName, err := ensemble.FeatureImportanceWeightByName()
+	assert.NilError(t, err)
+	assert.Equal(t, len(importanceByName), len(importance))
+	featMap, err := loadFeatureMap("test/data/breast_cancer_fmap.txt")
+	assert.NilError(t, err)
+	for name, count := range importanceByName {
+		assert.Equal(t, importance[featMap[name]], count)
+	}
+}
+
+func TestEnsemble_FeatureImportanceGain(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+statsTreeJSON+","+statsTreeJSON+"]"),
+		"", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+
+	importance, err := ensemble.FeatureImportanceGain()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, importance, map[int]float64{0: 8, 1: 5, 2: 25})
+
+	// dump without statistics.
+	ensemble, err = LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"),
+		"", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+	importance, err = ensemble.FeatureImportanceGain()
+	assert.NilError(t, err)
+	assert.Equal(t, len(importance), 0)
+}
+
+func TestEnsemble_Metadata(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	assert.Equal(t, ensemble.NumTrees(), 30)
+	assert.Equal(t, ensemble.NumClasses(), 3)
+	assert.Equal(t, ensemble.NumFeatures(), 4)
+	// dump_model json does not record the objective.
+	assert.Equal(t, ensemble.Objective(), "")
+}
+
+func TestEnsemble_CategoricalSplit(t *testing.T) {
+	model := `[
+	{ "nodeid": 0, "depth": 0, "split": "f0", "split_type": 1, "categories": [1, 3], "yes": 1, "no": 2, "missing": 2,
+	  "children": [
+	  { "nodeid": 1, "leaf": 0.5 },
+	  { "nodeid": 2, "depth": 1, "split": "f1", "split_condition": 0.5, "yes": 3, "no": 4, "missing": 3, "children": [
+	    { "nodeid": 3, "leaf": -0.1 },
+	    { "nodeid": 4, "leaf": -0.2 }
+	  ]}
+	]}]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		features mat.Vector
+		expected float64
+	}{
+		{mat.Vector{1, 0}, 0.5},
+		{mat.Vector{3, 1}, 0.5},
+		{mat.Vector{2, 0}, -0.1},
+		{mat.Vector{0, 1}, -0.2},
+		{mat.Vector{math.NaN(), 1}, -0.2},
+	}
+	for _, tc := range tests {
+		pred, err := ensemble.PredictRow(tc.features)
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], tc.expected, "features %v", tc.features)
+	}
+
+	var buf bytes.Buffer
+	assert.NilError(t, ensemble.DumpJSON(&buf))
+	dumped, err := LoadXGBoostFromJSONBytes(buf.Bytes(), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	assert.Assert(t, reflect.DeepEqual(ensemble.EnsembleBase, dumped.EnsembleBase))
+}
+
+func TestEnsemble_PredictMixed(t *testing.T) {
+	// feature 0 is a color with red, green, blue and black categories, feature 1 is numeric.
+	model := `[
+	{ "nodeid": 0, "depth": 0, "split": "f0", "split_type": 1, "categories": [1, 3], "yes": 1, "no": 2, "missing": 2,
+	  "children": [
+	  { "nodeid": 1, "leaf": 0.5 },
+	  { "nodeid": 2, "depth": 1, "split": "f1", "split_condition": 0.5, "yes": 3, "no": 4, "missing": 3, "children": [
+	    { "nodeid": 3, "leaf": -0.1 },
+	    { "nodeid": 4, "leaf": -0.2 }
+	  ]}
+	]}]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	vocabulary := inference.CategoryVocabulary{0: {"red", "green", "blue", "black"}}
+
+	tests := []struct {
+		features map[int]interface{}
+		expected float64
+	}{
+		{map[int]interface{}{0: "green", 1: 0.0}, 0.5},
+		{map[int]interface{}{0: "black", 1: 1.0}, 0.5},
+		{map[int]interface{}{0: "blue", 1: 0}, -0.1},
+		{map[int]interface{}{0: "red", 1: 1}, -0.2},
+		{map[int]interface{}{0: nil, 1: 1.0}, -0.2},
+		{map[int]interface{}{1: 1.0}, -0.2},
+		// category codes are accepted as numbers too.
+		{map[int]interface{}{0: 3.0, 1: 1.0}, 0.5},
+	}
+	for _, tc := range tests {
+		pred, err := ensemble.PredictMixed(tc.features, vocabulary)
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], tc.expected, "features %v", tc.features)
+	}
+
+	_, err = ensemble.PredictMixed(map[int]interface{}{0: "white"}, vocabulary)
+	assert.Error(t, err, `unknown category "white" of feature 0`)
+	_, err = ensemble.PredictMixed(map[int]interface{}{1: "high"}, vocabulary)
+	assert.Error(t, err, "feature 1 has no category vocabulary")
+	_, err = ensemble.PredictMixed(map[int]interface{}{1: true}, vocabulary)
+	assert.Error(t, err, "feature 1 has value of unsupported type bool")
+}
+
+func TestEnsemble_NumParallelTree(t *testing.T) {
+	// 2 boosting rounds, 2 classes and 2 parallel trees: round 0 class 0, round 0 class 1, round 1 class 0, ...
+	model := `[
+	{ "nodeid": 0, "leaf": 0.1 }, { "nodeid": 0, "leaf": 0.3 },
+	{ "nodeid": 0, "leaf": -0.2 }, { "nodeid": 0, "leaf": -0.4 },
+	{ "nodeid": 0, "split": "f0", "split_condition": 0.5, "yes": 1, "no": 2, "missing": 1, "children": [
+	  { "nodeid": 1, "leaf": 0.5 }, { "nodeid": 2, "leaf": 1.5 }
+	]},
+	{ "nodeid": 0, "leaf": 0.7 },
+	{ "nodeid": 0, "leaf": 0.2 }, { "nodeid": 0, "leaf": 0.6 }
+	]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 2, 0, &activation.Raw{},
+		WithNumParallelTree(2))
+	assert.NilError(t, err)
+
+	pred, err := ensemble.PredictRow(mat.Vector{0})
+	assert.NilError(t, err)
+	expected := mat.Vector{(0.1+0.3)/2 + (0.5+0.7)/2, (-0.2-0.4)/2 + (0.2+0.6)/2}
+	assert.NilError(t, mat.IsEqualVectors(&pred, &expected, 1e-9))
+
+	sparsePred, err := ensemble.PredictSparse(mat.SparseVector{0: 1})
+	assert.NilError(t, err)
+	expected = mat.Vector{(0.1+0.3)/2 + (1.5+0.7)/2, (-0.2-0.4)/2 + (0.2+0.6)/2}
+	assert.NilError(t, mat.IsEqualVectors(&sparsePred, &expected, 1e-9))
+
+	_, err = LoadXGBoostFromJSONBytes([]byte(model), "", 2, 0, &activation.Raw{}, WithNumParallelTree(3))
+	assert.ErrorContains(t, err, "wrong number of trees")
+}
+
+// breastCancerDenseInput returns the breast cancer test set as a dense matrix repeated n times.
+func breastCancerDenseInput(t testing.TB, numFeatures, n int) mat.Matrix {
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+	dense := mat.Matrix{Vectors: make([]*mat.Vector, 0, n*len(input.Vectors))}
+	for k := 0; k < n; k++ {
+		for _, row := range input.Vectors {
+			vec := make(mat.Vector, numFeatures)
+			for i := range vec {
+				vec[i] = math.NaN()
+			}
+			for idx, v := range row {
+				vec[idx] = v
+			}
+			dense.Vectors = append(dense.Vectors, &vec)
+		}
+	}
+	return dense
+}
+
+func TestEnsemble_PredictBatchParallel(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 3)
+
+	expectedPredPath := "test/data/breast_cancer_xgboost_true_prediction.txt"
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix(expectedPredPath, "\t", 0.0)
+	assert.NilError(t, err)
+	serial, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	first := mat.Matrix{Vectors: serial.Vectors[:len(expectedProb.Vectors)]}
+	assert.NilError(t, mat.IsEqualMatrices(&first, &expectedProb, 0.0001))
+
+	for _, workers := range []int{0, 1, 4, 7, len(input.Vectors) + 1} {
+		parallel, err := ensemble.PredictBatchParallel(input, workers)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualMatrices(&parallel, &serial, 0))
+	}
+
+	input.Vectors[100] = &mat.Vector{1}
+	_, err = ensemble.PredictBatchParallel(input, 4)
+	assert.ErrorContains(t, err, "row 100")
+}
+
+// cancelAfter is an activation cancelling a context after n transformed rows.
+type cancelAfter struct {
+	activation.Activation
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Transform(rawPredictions mat.Vector) (mat.Vector, error) {
+	c.n--
+	if c.n == 0 {
+		c.cancel()
+	}
+	return c.Activation.Transform(rawPredictions)
+}
+
+func TestEnsemble_PredictBatchCtx(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 30)
+	expected, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictBatchCtx(context.Background(), input)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ensemble.PredictBatchCtx(ctx, input)
+	assert.Equal(t, err, context.Canceled)
+
+	// the context is cancelled while predicting the second chunk of rows.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	counter := &cancelAfter{Activation: ensemble.Activation, n: 1500, cancel: cancel}
+	ensemble.Activation = counter
+	_, err = ensemble.PredictBatchCtx(ctx, input)
+	assert.Equal(t, err, context.Canceled)
+	// rows of the second chunk are predicted, the third chunk is not started.
+	assert.Equal(t, counter.n, 1500-2048)
+}
+
+func BenchmarkEnsemble_PredictBatch(b *testing.B) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(b, err)
+	input := breastCancerDenseInput(b, ensemble.NumFeatures(), 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ensemble.PredictBatch(input)
+		assert.NilError(b, err)
+	}
+}
+
+// toDenseMatrix copies the rows of a matrix into a dense matrix.
+func toDenseMatrix(tb testing.TB, m mat.Matrix) mat.DenseMatrix {
+	cols := len(*m.Vectors[0])
+	values := make([]float64, 0, len(m.Vectors)*cols)
+	for _, row := range m.Vectors {
+		values = append(values, *row...)
+	}
+	dense, err := mat.NewDenseMatrix(len(m.Vectors), cols, values)
+	assert.NilError(tb, err)
+	return dense
+}
+
+func TestEnsemble_PredictMatrix(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 1)
+	expected, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictMatrix(toDenseMatrix(t, input))
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0))
+
+	_, err = ensemble.PredictMatrix(mat.DenseMatrix{Rows: 1, Cols: 2, Values: []float64{1, 2}})
+	assert.ErrorContains(t, err, "matrix has 2 features")
+}
+
+func TestEnsemble_PredictExtraFeatures(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	input := irisDenseInput(t)
+	expected, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+
+	// trailing columns the trees never reference are ignored.
+	padded := mat.Matrix{Vectors: make([]*mat.Vector, len(input.Vectors))}
+	for i, row := range input.Vectors {
+		wide := make(mat.Vector, 100)
+		copy(wide, *row)
+		for j := len(*row); j < len(wide); j++ {
+			wide[j] = float64(j)
+		}
+		padded.Vectors[i] = &wide
+
+		pred, err := ensemble.PredictRow(wide)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualVectors(&pred, expected.Vectors[i], 0))
+	}
+	predictions, err := ensemble.PredictBatch(padded)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0))
+	predictions, err = ensemble.PredictMatrix(toDenseMatrix(t, padded))
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0))
+}
+
+// denseBenchmarkRows is the number of rows of the DenseMatrix and Matrix benchmarks, about 100k rows.
+const denseBenchmarkRows = 880
+
+func BenchmarkEnsemble_PredictMatrix(b *testing.B) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(b, err)
+	input := toDenseMatrix(b, breastCancerDenseInput(b, ensemble.NumFeatures(), denseBenchmarkRows))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ensemble.PredictMatrix(input)
+		assert.NilError(b, err)
+	}
+}
+
+func BenchmarkEnsemble_PredictBatchSliceOfRows(b *testing.B) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(b, err)
+	input := breastCancerDenseInput(b, ensemble.NumFeatures(), denseBenchmarkRows)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ensemble.PredictBatch(input)
+		assert.NilError(b, err)
+	}
+}
+
+func BenchmarkEnsemble_PredictBatchParallel(b *testing.B) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(b, err)
+	input := breastCancerDenseInput(b, ensemble.NumFeatures(), 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ensemble.PredictBatchParallel(input, 0)
+		assert.NilError(b, err)
+	}
+}
+
+func TestFlatTree_PredictDense(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json",
+		"", 1, 0, &activation.Logistic{})
+	assert.NilError(t, err)
+	xgb := ensemble.EnsembleBase.(*xgbEnsemble)
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 1)
+
+	for _, row := range input.Vectors {
+		for i, tree := range xgb.Trees {
+			expected, err := tree.predictDense(*row)
+			assert.NilError(t, err)
+			p, err := xgb.flatTrees[i].predictDense(*row)
+			assert.NilError(t, err)
+			assert.Equal(t, p, expected)
+		}
+	}
+}
+
+// scaledIrisTrees returns an ensemble with the iris trees repeated n times and a dense iris input.
+func scaledIrisTrees(b *testing.B, n int) (*xgbEnsemble, mat.Matrix) {
+	data, err := ioutil.ReadFile("test/data/iris_xgboost_dump.json")
+	assert.NilError(b, err)
+	trees := strings.TrimSpace(string(data))
+	trees = trees[1 : len(trees)-1]
+	model := "[" + strings.Repeat(trees+",", n-1) + trees + "]"
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 3, 4, &activation.Softmax{})
+	assert.NilError(b, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(b, err)
+	dense := mat.Matrix{Vectors: make([]*mat.Vector, len(input.Vectors))}
+	for i, row := range input.Vectors {
+		dense.Vectors[i] = &mat.Vector{row[0], row[1], row[2], row[3]}
+	}
+	return ensemble.EnsembleBase.(*xgbEnsemble), dense
+}
+
+func BenchmarkTree_PredictDense(b *testing.B) {
+	xgb, input := scaledIrisTrees(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, row := range input.Vectors {
+			for _, tree := range xgb.Trees {
+				_, _ = tree.predictDense(*row)
+			}
+		}
+	}
+}
+
+func BenchmarkFlatTree_PredictDense(b *testing.B) {
+	xgb, input := scaledIrisTrees(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, row := range input.Vectors {
+			for _, tree := range xgb.flatTrees {
+				_, _ = tree.predictDense(*row)
+			}
+		}
+	}
+}
+
+func BenchmarkEnsemble_PredictRow(b *testing.B) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json",
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(b, err)
+	features := mat.Vector{5.8, 2.8, 5.1, 2.4}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ensemble.PredictRow(features)
+		assert.NilError(b, err)
+	}
+}
+
+func BenchmarkEnsemble_PredictInto(b *testing.B) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json",
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(b, err)
+	features := mat.Vector{5.8, 2.8, 5.1, 2.4}
+	out := make(mat.Vector, 3)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ensemble.PredictInto(features, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEnsemble_NumOutputGroups(t *testing.T) {
+	iris, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	binary, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	quantiles, err := LoadXGBoostFromSaveModelReader(strings.NewReader(
+		quantileModelJSON(`"[0.1, 0.5, 0.9]"`, "[0, 1, 2, 0, 1, 2]")))
+	assert.NilError(t, err)
+
+	tests := []struct {
+		ensemble *inference.Ensemble
+		expected int
+	}{
+		{iris, 3},
+		{binary, 1},
+		{quantiles, 3},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.ensemble.NumOutputGroups(), tc.expected, tc.ensemble.Name())
+		// it is the width of the transformed scores.
+		margin, err := tc.ensemble.PredictMargin(make(mat.Vector, tc.ensemble.NumFeatures()))
+		assert.NilError(t, err)
+		scores, err := tc.ensemble.Transform(margin)
+		assert.NilError(t, err)
+		assert.Equal(t, len(scores), tc.ensemble.NumOutputGroups())
+		assert.NilError(t, tc.ensemble.PredictInto(make(mat.Vector, tc.ensemble.NumFeatures()),
+			make(mat.Vector, tc.ensemble.NumOutputGroups())))
+	}
+}
+
+func TestEnsemble_PredictInto(t *testing.T) {
+	breastCancerRow := *breastCancerDenseInput(t, 30, 1).Vectors[0]
+	tests := []struct {
+		modelPath  string
+		numClasses int
+		activation activation.Activation
+		features   mat.Vector
+	}{
+		{"test/data/iris_xgboost_dump.json", 3, &activation.Softmax{}, mat.Vector{5.8, 2.8, 5.1, 2.4}},
+		{"test/data/breast_cancer_xgboost_dump.json", 1, &activation.Logistic{}, breastCancerRow},
+		{"test/data/breast_cancer_xgboost_dump_regression.json", 1, &activation.Raw{}, breastCancerRow},
+	}
+	for _, tc := range tests {
+		ensemble, err := LoadXGBoostFromJSON(tc.modelPath, "", tc.numClasses, 0, tc.activation)
+		assert.NilError(t, err)
+		expected, err := ensemble.PredictRow(tc.features)
+		assert.NilError(t, err)
+
+		// a larger buffer only has its first elements written.
+		out := make(mat.Vector, tc.numClasses+1)
+		out[tc.numClasses] = -1
+		assert.NilError(t, ensemble.PredictInto(tc.features, out))
+		assert.DeepEqual(t, out[:tc.numClasses], expected)
+		assert.Equal(t, out[tc.numClasses], -1.0)
+
+		allocs := testing.AllocsPerRun(100, func() {
+			_ = ensemble.PredictInto(tc.features, out)
+		})
+		assert.Equal(t, allocs, 0.0, tc.modelPath)
+
+		err = ensemble.PredictInto(tc.features, make(mat.Vector, tc.numClasses-1))
+		assert.ErrorContains(t, err, "must be at least number of classes")
+	}
+}
+
+func TestEnsemble_PredictRowTooFewFeatures(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	_, err = ensemble.PredictRow(mat.Vector{5.8, 2.8})
+	assert.Error(t, err, "expected at least 4 features, got 2")
+	_, err = ensemble.PredictMargin(mat.Vector{})
+	assert.Error(t, err, "expected at least 4 features, got 0")
+}
+
+func TestEnsemble_IrisSoftprob(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 3, 4, activation.FromObjective("multi:softprob"))
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	for _, pred := range predictions.Vectors {
+		assert.Equal(t, len(*pred), 3)
+		sum := 0.0
+		for _, p := range *pred {
+			sum += p
+		}
+		assert.Check(t, math.Abs(sum-1) < 1e-6, "sum of probabilities %f", sum)
+	}
+}
+
+func TestEnsemble_BreastCancerLogisticPrecision(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, activation.FromObjective("binary:logistic"))
+	assert.NilError(t, err)
+
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 1)
+	predictions, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	for _, pred := range predictions.Vectors {
+		assert.Equal(t, len(*pred), 1)
+		assert.Check(t, (*pred)[0] >= 0 && (*pred)[0] <= 1)
+	}
+
+	expectedPredPath := "test/data/breast_cancer_xgboost_true_prediction.txt"
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix(expectedPredPath, "\t", 0.0)
+	assert.NilError(t, err)
+	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.00001)
+	assert.NilError(t, err)
+}
+
+// plattScaling is a custom activation calibrating binary margins.
+type plattScaling struct {
+	a, b float64
+}
+
+func (p *plattScaling) Transform(rawPredictions mat.Vector) (mat.Vector, error) {
+	return mat.Vector{1.0 / (1.0 + math.Exp(p.a*rawPredictions[0]+p.b))}, nil
+}
+
+func (p *plattScaling) Type() protobuf.ActivateType {
+	return protobuf.ActivateType_UNKNOWN
+}
+
+func (p *plattScaling) Name() string {
+	return "platt"
+}
+
+func TestEnsemble_CustomActivation(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath,
+		"", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+	expected, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+
+	// platt scaling with a=-1 and b=0 is the logistic function.
+	ensemble.Activation = &plattScaling{a: -1, b: 0}
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 1e-9))
+
+	ensemble.Activation = &plattScaling{a: -2, b: 0.5}
+	predictions, err = ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	assert.Check(t, mat.IsEqualMatrices(&predictions, &expected, 1e-3) != nil)
+}
+
+func TestEnsemble_PredictWithLimit(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/iris_xgboost_dump.json")
+	assert.NilError(t, err)
+	var trees []json.RawMessage
+	assert.NilError(t, json.Unmarshal(data, &trees))
+	// first 4 boosting rounds of the iris model.
+	truncated, err := json.Marshal(trees[:12])
+	assert.NilError(t, err)
+
+	ensemble, err := LoadXGBoostFromJSONBytes(data, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	truncatedEnsemble, err := LoadXGBoostFromJSONBytes(truncated, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	features := mat.Vector{6.0, 2.2, 4.0, 1.0}
+	pred, err := ensemble.PredictWithLimit(features, 4)
+	assert.NilError(t, err)
+	expected, err := truncatedEnsemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualVectors(&pred, &expected, 0))
+
+	pred, err = ensemble.PredictWithLimit(features, 0)
+	assert.NilError(t, err)
+	expected, err = ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualVectors(&pred, &expected, 0))
+
+	_, err = ensemble.PredictWithLimit(features, -1)
+	assert.ErrorContains(t, err, "tree limit")
+}
+
+func TestNewWeightedEnsemble(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/iris_xgboost_dump.json")
+	assert.NilError(t, err)
+	var trees []json.RawMessage
+	assert.NilError(t, json.Unmarshal(data, &trees))
+	truncated, err := json.Marshal(trees[:12])
+	assert.NilError(t, err)
+
+	full, err := LoadXGBoostFromJSONBytes(data, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	small, err := LoadXGBoostFromJSONBytes(truncated, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	blend, err := inference.NewWeightedEnsemble([]*inference.Ensemble{full, small}, []float64{1, 3})
+	assert.NilError(t, err)
+	assert.Equal(t, blend.NumClasses(), 3)
+	assert.Equal(t, blend.NumFeatures(), 4)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	proba, err := blend.PredictProba(input)
+	assert.NilError(t, err)
+	for i, row := range input.Vectors {
+		dense := mat.Vector{row[0], row[1], row[2], row[3]}
+		p1, err := full.PredictRow(dense)
+		assert.NilError(t, err)
+		p2, err := small.PredictRow(dense)
+		assert.NilError(t, err)
+		expected := make(mat.Vector, 3)
+		for c := range expected {
+			expected[c] = 0.25*p1[c] + 0.75*p2[c]
+		}
+		pred, err := blend.PredictRow(dense)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualVectors(&pred, &expected, 1e-12))
+		assert.NilError(t, mat.IsEqualVectors(proba.Vectors[i], &expected, 1e-12))
+	}
+
+	breastCancer, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 0,
+		&activation.Logistic{})
+	assert.NilError(t, err)
+	_, err = inference.NewWeightedEnsemble([]*inference.Ensemble{full, breastCancer}, []float64{1, 1})
+	assert.ErrorContains(t, err, "model 1 has 1 classes, expected 3 classes")
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch))
+	_, err = inference.NewWeightedEnsemble([]*inference.Ensemble{full, small}, []float64{1})
+	assert.ErrorContains(t, err, "must match number of weights")
+	_, err = inference.NewWeightedEnsemble([]*inference.Ensemble{full, small}, []float64{0, 0})
+	assert.ErrorContains(t, err, "weights sum to 0")
+}
+
+func TestEnsemble_Summary(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.Summary(),
+		"xgboost: classes=3 features=4 activation=SOFTMAX trees=30 nodes=176 avg_depth=2.10 max_depth=4")
+
+	blend, err := inference.NewWeightedEnsemble([]*inference.Ensemble{ensemble}, []float64{1})
+	assert.NilError(t, err)
+	assert.Equal(t, blend.Summary(), "weighted: classes=3 features=4 activation=RAW")
+}
+
+func TestEnsemble_ApproxMemoryBytes(t *testing.T) {
+	stump := `{ "nodeid": 0, "leaf": 0.5 }`
+	memory := func(trees ...string) int {
+		ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+strings.Join(trees, ",")+"]"), "", 1, 0,
+			&activation.Raw{})
+		assert.NilError(t, err)
+		return ensemble.ApproxMemoryBytes()
+	}
+
+	// every tree adds the same size, which grows with its number of nodes.
+	one := memory(twoLevelTreeJSON)
+	two := memory(twoLevelTreeJSON, twoLevelTreeJSON)
+	three := memory(twoLevelTreeJSON, twoLevelTreeJSON, twoLevelTreeJSON)
+	assert.Assert(t, one > 0)
+	assert.Equal(t, three-two, two-one)
+	withStump := memory(twoLevelTreeJSON, stump)
+	assert.Assert(t, withStump-one > 0 && withStump-one < two-one, "%d %d %d", one, two, withStump)
+
+	iris, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	assert.Assert(t, iris.ApproxMemoryBytes() > 10*one)
+	blend, err := inference.NewWeightedEnsemble([]*inference.Ensemble{iris, iris}, []float64{1, 1})
+	assert.NilError(t, err)
+	assert.Assert(t, blend.ApproxMemoryBytes() > 2*iris.ApproxMemoryBytes())
+	custom := &inference.Ensemble{EnsembleBase: struct{ inference.EnsembleBase }{iris.EnsembleBase}}
+	assert.Equal(t, custom.ApproxMemoryBytes(), 0)
+}
+
+func TestEnsemble_TreeStats(t *testing.T) {
+	maxDepth := 4
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, maxDepth, &activation.Softmax{})
+	assert.NilError(t, err)
+	stats, err := ensemble.TreeStats()
+	assert.NilError(t, err)
+	assert.Equal(t, len(stats), 30)
+	for i, stat := range stats {
+		assert.Equal(t, stat.Index, i)
+		assert.Assert(t, stat.Depth <= maxDepth, "tree %d has depth %d", i, stat.Depth)
+		// every split node has 2 children.
+		assert.Equal(t, stat.Nodes, 2*stat.Leaves-1)
+	}
+	assert.DeepEqual(t, stats[0], inference.TreeStat{Index: 0, Nodes: 3, Leaves: 2, Depth: 1})
+
+	ensemble, err = LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"), "", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+	stats, err = ensemble.TreeStats()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stats, []inference.TreeStat{{Index: 0, Nodes: 7, Leaves: 4, Depth: 2}})
+}
+
+func TestEnsemble_Walk(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	stats, err := ensemble.TreeStats()
+	assert.NilError(t, err)
+
+	nodes := make([]int, len(stats))
+	leaves := make([]int, len(stats))
+	depths := make([]int, len(stats))
+	assert.NilError(t, ensemble.Walk(func(treeIndex int, node inference.NodeView) bool {
+		nodes[treeIndex]++
+		if node.IsLeaf {
+			leaves[treeIndex]++
+		}
+		if node.Depth > depths[treeIndex] {
+			depths[treeIndex] = node.Depth
+		}
+		return true
+	}))
+	for i, stat := range stats {
+		assert.Equal(t, nodes[i], stat.Nodes, "tree %d", i)
+		assert.Equal(t, leaves[i], stat.Leaves, "tree %d", i)
+		assert.Equal(t, depths[i], stat.Depth, "tree %d", i)
+	}
+
+	// nodes are visited depth first and returning false stops the walk.
+	var visited []inference.NodeView
+	small, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+","+twoLevelTreeJSON+"]"), "", 1, 0,
+		&activation.Raw{})
+	assert.NilError(t, err)
+	assert.NilError(t, small.Walk(func(treeIndex int, node inference.NodeView) bool {
+		visited = append(visited, node)
+		return len(visited) < 3
+	}))
+	assert.DeepEqual(t, visited, []inference.NodeView{
+		{ID: 0, Feature: 2, Threshold: 2.5, Yes: 1, No: 2, Missing: 1},
+		{ID: 1, Depth: 1, Feature: 0, Threshold: 1.5, Yes: 3, No: 4, Missing: 3},
+		{ID: 3, Depth: 2, IsLeaf: true, LeafValue: 0.1},
+	})
+
+	linear, err := LoadXGBoostLinearFromReader(strings.NewReader(multiclassLinearDumpJSON), 3, nil)
+	assert.NilError(t, err)
+	err = linear.Walk(func(int, inference.NodeView) bool { return true })
+	assert.Error(t, err, "gblinear model is not a tree ensemble")
+}
+
+func TestEnsemble_TreeFillRatio(t *testing.T) {
+	model := "[" + statsTreeJSON + `, { "nodeid": 0, "leaf": 0.5 }]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	for i, expected := range []float64{1, 1} {
+		ratio, err := ensemble.TreeFillRatio(i)
+		assert.NilError(t, err)
+		assert.Equal(t, ratio, expected)
+	}
+	// node 2 becomes a leaf, leaving 5 of the 7 nodes of a depth 2 tree.
+	_, err = ensemble.PruneByGain(3)
+	assert.NilError(t, err)
+	ratio, err := ensemble.TreeFillRatio(0)
+	assert.NilError(t, err)
+	assert.Equal(t, ratio, 5.0/7.0)
+
+	_, err = ensemble.TreeFillRatio(2)
+	assert.Error(t, err, "tree index 2 out of range [0, 2)")
+	_, err = ensemble.TreeFillRatio(-1)
+	assert.Error(t, err, "tree index -1 out of range [0, 2)")
+
+	ensemble, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	stats, err := ensemble.TreeStats()
+	assert.NilError(t, err)
+	for _, stat := range stats {
+		ratio, err := ensemble.TreeFillRatio(stat.Index)
+		assert.NilError(t, err)
+		assert.Equal(t, ratio, float64(stat.Nodes)/float64(int(math.Pow(2, float64(stat.Depth+1)))-1))
+		assert.Assert(t, ratio > 0 && ratio <= 1)
+	}
+}
+
+// traverse routes a dense feature vector through a tree view the same way the model does.
+func traverse(tree inference.TreeView, features mat.Vector) float64 {
+	node := 0
+	for !tree.IsLeaf(node) {
+		v := features[tree.Feature(node)]
+		switch {
+		case math.IsNaN(v):
+			node = tree.Missing(node)
+		case tree.IsCategorical(node):
+			next := tree.No(node)
+			for _, c := range tree.Categories(node) {
+				if int(v) == c {
+					next = tree.Yes(node)
+				}
+			}
+			node = next
+		case v < tree.Threshold(node):
+			node = tree.Yes(node)
+		default:
+			node = tree.No(node)
+		}
+	}
+	return tree.LeafValue(node)
+}
+
+func TestEnsemble_Tree(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	input := irisDenseInput(t)
+	input.Vectors = append(input.Vectors, &mat.Vector{5.0, math.NaN(), 4.5, math.NaN()})
+	for _, row := range input.Vectors {
+		margin, err := ensemble.PredictMargin(*row)
+		assert.NilError(t, err)
+		expected := make(mat.Vector, 3)
+		for i := 0; i < ensemble.NumTrees(); i++ {
+			tree, err := ensemble.Tree(i)
+			assert.NilError(t, err)
+			expected[i%3] += traverse(tree, *row)
+		}
+		assert.NilError(t, mat.IsEqualVectors(&margin, &expected, 1e-12))
+	}
+
+	tree, err := ensemble.Tree(0)
+	assert.NilError(t, err)
+	assert.Equal(t, tree.NumNodes(), 3)
+	assert.Assert(t, !tree.IsLeaf(0) && tree.HasNode(0))
+	assert.Equal(t, tree.Feature(0), 2)
+	assert.Equal(t, tree.Threshold(0), 2.3499999)
+	assert.Equal(t, tree.LeafValue(1), 1.41818178)
+	assert.Assert(t, !tree.HasNode(3))
+	assert.Equal(t, tree.LeafValue(3), 0.0)
+
+	_, err = ensemble.Tree(30)
+	assert.ErrorContains(t, err, "tree index 30 out of range [0, 30)")
+	_, err = ensemble.Tree(-1)
+	assert.ErrorContains(t, err, "out of range")
+}
+
+func TestEnsemble_PredictCSV(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump_fmap.json",
+		"test/data/breast_cancer_fmap.txt", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	features, err := loadFeatureInfo("test/data/breast_cancer_fmap.txt")
+	assert.NilError(t, err)
+	names := make([]string, 30)
+	for name, info := range features {
+		if info.index < 30 {
+			names[info.index] = name
+		}
+	}
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+
+	// header with an id column and features in reverse order, absent features are empty cells.
+	var csvInput strings.Builder
+	csvInput.WriteString("id")
+	for i := 29; i >= 0; i-- {
+		csvInput.WriteString("," + names[i])
+	}
+	csvInput.WriteString("\n")
+	for r, row := range input.Vectors {
+		csvInput.WriteString(strconv.Itoa(r))
+		for i := 29; i >= 0; i-- {
+			csvInput.WriteString(",")
+			if v, ok := row[i]; ok {
+				csvInput.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		csvInput.WriteString("\n")
+	}
+
+	var out bytes.Buffer
+	assert.NilError(t, ensemble.PredictCSV(strings.NewReader(csvInput.String()), &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), len(input.Vectors))
+	for r, row := range input.Vectors {
+		expected, err := ensemble.PredictSparse(row)
+		assert.NilError(t, err)
+		pred, err := strconv.ParseFloat(lines[r], 64)
+		assert.NilError(t, err)
+		assert.Equal(t, pred, expected[0])
+	}
+
+	// without header features are in column order.
+	iris, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	out.Reset()
+	assert.NilError(t, iris.PredictCSV(strings.NewReader("6.0,2.2,4.0,1.0\n5.0,,4.5,\n"), &out))
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+	for i, row := range []mat.Vector{{6.0, 2.2, 4.0, 1.0}, {5.0, math.NaN(), 4.5, math.NaN()}} {
+		expected, err := iris.PredictRow(row)
+		assert.NilError(t, err)
+		cells := strings.Split(lines[i], ",")
+		assert.Equal(t, len(cells), 3)
+		for c, cell := range cells {
+			pred, err := strconv.ParseFloat(cell, 64)
+			assert.NilError(t, err)
+			assert.Equal(t, pred, expected[c])
+		}
+	}
+
+	err = iris.PredictCSV(strings.NewReader("6.0,2.2,4.0,1.0\n6.0,x,4.0,1.0\n"), &out)
+	assert.ErrorContains(t, err, "line 2 column 1")
+}
+
+func TestEnsemble_ConcurrentPredict(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	dense := irisDenseInput(t)
+	expected, err := ensemble.Predict(input)
+	assert.NilError(t, err)
+	expectedProba, err := ensemble.PredictBatch(dense)
+	assert.NilError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for g := 0; g < 100; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pred, err := ensemble.Predict(input)
+			if err == nil {
+				err = mat.IsEqualMatrices(&pred, &expected, 0)
+			}
+			for i := 0; err == nil && i < len(dense.Vectors); i++ {
+				var proba mat.Vector
+				proba, err = ensemble.PredictRow(*dense.Vectors[i])
+				if err == nil {
+					err = mat.IsEqualVectors(&proba, expectedProba.Vectors[i], 0)
+				}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NilError(t, err)
+	}
+}
+
+func TestEnsemble_Clone(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	dense := irisDenseInput(t)
+	expected, err := ensemble.PredictBatch(dense)
+	assert.NilError(t, err)
+
+	clone, err := ensemble.Clone()
+	assert.NilError(t, err)
+	clone.Activation = &activation.Raw{}
+	clone.BaseScore = 1
+	xgb := clone.EnsembleBase.(*xgbEnsemble)
+	for _, tree := range xgb.Trees {
+		for _, node := range tree.nodes {
+			node.LeafValues = 0
+		}
+	}
+	xgb.flatten()
+
+	predictions, err := ensemble.PredictBatch(dense)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0))
+	assert.Equal(t, ensemble.Type(), protobuf.ActivateType_SOFTMAX)
+	pred, err := clone.PredictRow(*dense.Vectors[0])
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pred, mat.Vector{1, 1, 1})
+
+	// the wrapper only exposes the methods of EnsembleBase.
+	wrapped := &inference.Ensemble{EnsembleBase: struct{ inference.EnsembleBase }{xgb}}
+	_, err = wrapped.Clone()
+	assert.ErrorContains(t, err, "cannot be cloned")
+}
+
+func TestEnsemble_PruneByGain(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+statsTreeJSON+"]"), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	features := mat.Vector{1.0, 0.0, 3.0}
+
+	// only node 2 (gain 2.5) is collapsed, its leaves 0.3 and 0.4 have covers 3 and 1.
+	removed, err := ensemble.PruneByGain(3)
+	assert.NilError(t, err)
+	assert.Equal(t, removed, 2)
+	pred, err := ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.Assert(t, math.Abs(pred[0]-0.325) < 1e-12)
+	sparse, err := ensemble.PredictSparse(mat.SparseVector{0: 1.0, 1: 0.0, 2: 3.0})
+	assert.NilError(t, err)
+	assert.Assert(t, math.Abs(sparse[0]-0.325) < 1e-12)
+
+	// the whole tree collapses into a leaf holding its mean.
+	removed, err = ensemble.PruneByGain(100)
+	assert.NilError(t, err)
+	assert.Equal(t, removed, 4)
+	stats, err := ensemble.TreeStats()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stats, []inference.TreeStat{{Index: 0, Nodes: 1, Leaves: 1, Depth: 0}})
+	pred, err = ensemble.PredictRow(features)
+	assert.NilError(t, err)
+	assert.Assert(t, math.Abs(pred[0]-0.21) < 1e-12)
+
+	ensemble, err = LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 0, &activation.Logistic{})
+	assert.NilError(t, err)
+	_, err = ensemble.PruneByGain(1)
+	assert.ErrorContains(t, err, "has no cover")
+
+	// gains are not dumped, splits reached by few rows have a small gain here.
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 1)
+	setCovers(t, ensemble, input)
+	for _, tree := range ensemble.EnsembleBase.(*xgbEnsemble).Trees {
+		for _, node := range tree.nodes {
+			if node != nil && node.Flags&isLeaf == 0 {
+				node.Gain = node.Cover
+			}
+		}
+	}
+	expected, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	before, err := ensemble.TreeStats()
+	assert.NilError(t, err)
+	removed, err = ensemble.PruneByGain(5)
+	assert.NilError(t, err)
+	assert.Assert(t, removed > 0)
+	after, err := ensemble.TreeStats()
+	assert.NilError(t, err)
+	nodes := 0
+	for i := range before {
+		nodes += before[i].Nodes - after[i].Nodes
+	}
+	assert.Equal(t, nodes, removed)
+	predictions, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0.05))
+}
+
+func TestEnsemble_Remap(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump_fmap.json",
+		"test/data/breast_cancer_fmap.txt", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	numFeatures := ensemble.NumFeatures()
+	input := breastCancerDenseInput(t, numFeatures, 1)
+	expected, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+
+	// serve the features in reverse order, after one leading unused column.
+	mapping := make(map[int]int, numFeatures)
+	for i := 0; i < numFeatures; i++ {
+		mapping[i] = numFeatures - i
+	}
+	remapped, err := ensemble.Clone()
+	assert.NilError(t, err)
+	assert.NilError(t, remapped.Remap(mapping))
+	assert.Equal(t, remapped.NumFeatures(), numFeatures+1)
+	assert.Equal(t, ensemble.NumFeatures(), numFeatures)
+	assert.Equal(t, remapped.FeatureNames()[numFeatures], "mean_radius")
+	assert.Equal(t, ensemble.FeatureNames()[0], "mean_radius")
+
+	permuted := mat.Matrix{Vectors: make([]*mat.Vector, len(input.Vectors))}
+	for r, row := range input.Vectors {
+		vec := make(mat.Vector, numFeatures+1)
+		vec[0] = math.NaN()
+		for i, v := range *row {
+			vec[mapping[i]] = v
+		}
+		permuted.Vectors[r] = &vec
+	}
+	predictions, err := remapped.PredictBatch(permuted)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 1e-12))
+	byName, err := remapped.PredictByName(map[string]float64{"mean_radius": 10})
+	assert.NilError(t, err)
+	original, err := ensemble.PredictByName(map[string]float64{"mean_radius": 10})
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualVectors(&byName, &original, 1e-12))
+
+	// invalid mappings leave the model unchanged.
+	delete(mapping, 0)
+	err = remapped.Remap(mapping)
+	assert.ErrorContains(t, err, "is not in the mapping")
+	mapping[0] = 1
+	err = remapped.Remap(mapping)
+	assert.ErrorContains(t, err, "are both mapped to index 1")
+	mapping[0] = -1
+	err = remapped.Remap(mapping)
+	assert.Error(t, err, "feature 0 is mapped to out of range index -1")
+	predictions, err = remapped.PredictBatch(permuted)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 1e-12))
+}
@@ -2,31 +2,237 @@ package xgboost
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"sort"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Elvenson/xgboost-go/activation"
 	"github.com/Elvenson/xgboost-go/inference"
 )
 
+// split types of xgboost json dump.
+const (
+	numericalSplit   = 0
+	categoricalSplit = 1
+)
+
+// LoadOption sets an optional parameter when loading a model.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	numParallelTree    int
+	featureMap         map[string]int
+	featureTypes       map[int]string
+	featureNames       []string
+	objective          string
+	numFeatures        int
+	loadWorkers        int
+	checkFeatureMap    bool
+	featureMapFallback bool
+	treeWeights        []float64
+	quantileAlphas     []float64
+	variancePower      float64
+	maxTrees           int
+	strictFields       bool
+	positionalSplits   bool
+}
+
+// newLoadOptions returns the default options updated by opts.
+func newLoadOptions(opts []LoadOption) loadOptions {
+	options := loadOptions{numParallelTree: 1, loadWorkers: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// WithNumParallelTree sets the number of parallel trees built per boosting round, the num_parallel_tree parameter
+// of DMLC XGBoost. Parallel trees of a round are averaged at prediction. Default is 1.
+func WithNumParallelTree(numParallelTree int) LoadOption {
+	return func(o *loadOptions) {
+		o.numParallelTree = numParallelTree
+	}
+}
+
+// WithTreeWeights sets the weight scaling the output of every tree, in tree order, like the weight_drop of DMLC
+// XGBoost DART boosters. It is read from save_model json of DART boosters, dump_model json does not record it.
+// Default weight of every tree is 1.
+func WithTreeWeights(weights []float64) LoadOption {
+	return func(o *loadOptions) {
+		o.treeWeights = weights
+	}
+}
+
+// WithFeatureMapValidation checks after load that every split feature index is within the bounds of the feature
+// map, which catches feature maps not matching the model. It has no effect without feature map.
+func WithFeatureMapValidation() LoadOption {
+	return func(o *loadOptions) {
+		o.checkFeatureMap = true
+	}
+}
+
+// WithFeatureMapFallback lets the feature map name only some features: split features absent from it must be
+// default names like "f12", which are parsed as feature index 12. Without it every split feature must be in the
+// feature map. It has no effect without feature map.
+func WithFeatureMapFallback() LoadOption {
+	return func(o *loadOptions) {
+		o.featureMapFallback = true
+	}
+}
+
+// WithMaxTrees loads only the first maxTrees trees of the model, which is faster and lighter when prototyping with a
+// large model. It must be a multiple of the number of trees per boosting round, the number of classes times the
+// number of parallel trees. Default is 0 which loads all trees.
+func WithMaxTrees(maxTrees int) LoadOption {
+	return func(o *loadOptions) {
+		o.maxTrees = maxTrees
+	}
+}
+
+// WithStrictFields makes loading dump_model json fail on node fields this package does not know, instead of
+// ignoring them, which surfaces models using features it does not support rather than mis-scoring them. It does not
+// apply to save_model json, which holds many fields not needed for prediction.
+func WithStrictFields() LoadOption {
+	return func(o *loadOptions) {
+		o.strictFields = true
+	}
+}
+
+// WithLoadConcurrency sets the number of goroutines building trees while loading a model, 1 loads trees serially.
+// Default is the number of CPUs.
+func WithLoadConcurrency(workers int) LoadOption {
+	return func(o *loadOptions) {
+		o.loadWorkers = workers
+	}
+}
+
+// WithFeatureMap sets an already parsed feature map mapping feature names to feature indices, it is an alternative
+// to the feature map path or parameter of the loaders and cannot be used together with it.
+func WithFeatureMap(featureMap map[string]int) LoadOption {
+	return func(o *loadOptions) {
+		o.featureMap = featureMap
+	}
+}
+
+// withFeatureTypes sets the feature types read from a feature map file.
+func withFeatureTypes(featureTypes map[int]string) LoadOption {
+	return func(o *loadOptions) {
+		o.featureTypes = featureTypes
+	}
+}
+
+// withFeatureNames sets the feature names recorded in the model by feature index, they are used unless a feature
+// map is given.
+func withFeatureNames(names []string) LoadOption {
+	return func(o *loadOptions) {
+		o.featureNames = names
+	}
+}
+
+// withPositionalSplits makes split features be read as feature indices, as save_model json records them, a feature
+// map then only names the features.
+func withPositionalSplits() LoadOption {
+	return func(o *loadOptions) {
+		o.positionalSplits = true
+	}
+}
+
+// withObjective sets the objective name recorded in the model.
+func withObjective(objective string) LoadOption {
+	return func(o *loadOptions) {
+		o.objective = objective
+	}
+}
+
+// withQuantileAlphas sets the quantile of every output of a quantile regression model.
+func withQuantileAlphas(alphas []float64) LoadOption {
+	return func(o *loadOptions) {
+		o.quantileAlphas = alphas
+	}
+}
+
+// withTweedieVariancePower sets the variance power of a tweedie regression model.
+func withTweedieVariancePower(power float64) LoadOption {
+	return func(o *loadOptions) {
+		o.variancePower = power
+	}
+}
+
+// withNumFeatures sets the number of features recorded in the model.
+func withNumFeatures(numFeatures int) LoadOption {
+	return func(o *loadOptions) {
+		o.numFeatures = numFeatures
+	}
+}
+
 type xgboostJSON struct {
 	NodeID                int            `json:"nodeid,omitempty"`
+	Depth                 int            `json:"depth,omitempty"`
 	SplitFeatureID        string         `json:"split,omitempty"`
-	SplitFeatureThreshold float64        `json:"split_condition,omitempty"`
+	SplitFeatureThreshold *float64       `json:"split_condition,omitempty"`
 	YesID                 int            `json:"yes,omitempty"`
 	NoID                  int            `json:"no,omitempty"`
 	MissingID             int            `json:"missing,omitempty"`
 	LeafValue             float64        `json:"leaf,omitempty"`
+	Gain                  float64        `json:"gain,omitempty"`
+	Cover                 float64        `json:"cover,omitempty"`
+	SplitType             int            `json:"split_type,omitempty"`
+	Categories            []int          `json:"categories,omitempty"`
 	Children              []*xgboostJSON `json:"children,omitempty"`
 }
 
-func loadFeatureMap(filePath string) (map[string]int, error) {
+// decompressReader wraps r with a gzip reader if the content starts with gzip magic header.
+func decompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// splitFeatureMapLine splits a feature map line into feature index, feature name and feature type. Fields are tab
+// separated, if there is no tab they are space separated and the feature name may contain spaces.
+func splitFeatureMapLine(line string) ([]string, error) {
+	var tk []string
+	if strings.Contains(line, "\t") {
+		tk = strings.Split(line, "\t")
+	} else {
+		first := strings.Index(line, " ")
+		last := strings.LastIndex(line, " ")
+		if first == -1 || first == last {
+			return nil, fmt.Errorf("wrong feature map format")
+		}
+		tk = []string{line[:first], line[first+1 : last], line[last+1:]}
+	}
+	for i := range tk {
+		tk[i] = strings.TrimSpace(tk[i])
+	}
+	if len(tk) != 3 || len(tk[1]) == 0 {
+		return nil, fmt.Errorf("wrong feature map format")
+	}
+	return tk, nil
+}
+
+// featureInfo contains feature index and feature type read from feature map.
+type featureInfo struct {
+	index       int
+	featureType string
+}
+
+func loadFeatureInfo(filePath string) (map[string]featureInfo, error) {
 	featureFile, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
@@ -34,51 +240,105 @@ func loadFeatureMap(filePath string) (map[string]int, error) {
 	defer featureFile.Close()
 
 	read := bufio.NewReader(featureFile)
-	featureMap := make(map[string]int, 0)
+	features := make(map[string]featureInfo, 0)
 	for {
 		// feature map format: feature_index feature_name feature_type
-		line, err := read.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		tk := strings.Split(line, " ")
-		if len(tk) != 3 {
-			return nil, fmt.Errorf("wrong feature map format")
+		line, readErr := read.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, readErr
 		}
-		featIdx, err := strconv.Atoi(tk[0])
-		if err != nil {
-			return nil, err
+		// the last line may not end with a new line.
+		line = strings.TrimSpace(line)
+		if len(line) != 0 {
+			tk, err := splitFeatureMapLine(line)
+			if err != nil {
+				return nil, err
+			}
+			featIdx, err := strconv.Atoi(tk[0])
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := features[tk[1]]; ok {
+				return nil, fmt.Errorf("duplicate feature name")
+			}
+			features[tk[1]] = featureInfo{index: featIdx, featureType: tk[2]}
 		}
-		if _, ok := featureMap[tk[1]]; ok {
-			return nil, fmt.Errorf("duplicate feature name")
+		if readErr == io.EOF {
+			break
 		}
-		featureMap[tk[1]] = featIdx
 	}
-	return featureMap, nil
+	if len(features) == 0 {
+		return nil, fmt.Errorf("empty feature map %s", filePath)
+	}
+	return features, nil
+}
+
+func loadFeatureMap(filePath string) (map[string]int, error) {
+	features, err := loadFeatureInfo(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return featureIndices(features), nil
 }
 
-func convertFeatToIdx(featureMap map[string]int, feature string) (int, error) {
+// featureIndices returns a feature map from feature names to feature indices.
+func featureIndices(features map[string]featureInfo) map[string]int {
+	featureMap := make(map[string]int, len(features))
+	for name, f := range features {
+		featureMap[name] = f.index
+	}
+	return featureMap
+}
+
+// featureTypes returns a map from feature indices to feature types.
+func featureTypes(features map[string]featureInfo) map[int]string {
+	types := make(map[int]string, len(features))
+	for _, f := range features {
+		types[f.index] = f.featureType
+	}
+	return types
+}
+
+// convertFeatToIdx returns the index of a split feature. Without feature map the name is either a bare index like
+// "12" or an index with a non digit prefix like the default "f12" or "feature_12". With a feature map and fallback,
+// a name absent from the map can still be a default name like "f12".
+func convertFeatToIdx(featureMap map[string]int, feature string, fallback bool) (int, error) {
 	if featureMap != nil {
-		if _, ok := featureMap[feature]; !ok {
-			return 0, fmt.Errorf("cannot find feature %s in feature map", feature)
+		if idx, ok := featureMap[feature]; ok {
+			return idx, nil
 		}
-		return featureMap[feature], nil
-
+		if fallback && len(feature) > 1 && feature[0] == 'f' {
+			if idx, err := strconv.Atoi(feature[1:]); err == nil && idx >= 0 {
+				return idx, nil
+			}
+		}
+		return 0, fmt.Errorf("%w %s in feature map", inference.ErrFeatureNotFound, feature)
 	}
 
-	// if no feature map use the default feature name which are: f0, f1, f2, ...
-	feature = feature[1:]
-	idx, err := strconv.Atoi(feature)
+	digits := strings.TrimLeftFunc(feature, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	idx, err := strconv.Atoi(digits)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("cannot parse feature index from feature name %q", feature)
 	}
 	return idx, nil
 }
 
-func buildTree(xgbTreeJSON *xgboostJSON, maxDepth int, featureMap map[string]int) (*xgbTree, int, error) {
+// isFinite reports whether v is neither NaN nor infinite.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+const (
+	// maxIndex is the largest node id and feature index, DMLC XGBoost stores both as 32 bits integers.
+	maxIndex = math.MaxInt32
+	// maxTreeDepth is the largest max depth whose node capacity fits node ids.
+	maxTreeDepth = 30
+)
+
+func buildTree(xgbTreeJSON *xgboostJSON, maxDepth int, featureMap map[string]int, fallback bool) (*xgbTree, int,
+	error) {
 	stack := make([]*xgboostJSON, 0)
 	maxFeatIdx := 0
 	t := &xgbTree{}
@@ -93,28 +353,64 @@ func buildTree(xgbTreeJSON *xgboostJSON, maxDepth int, featureMap map[string]int
 	for len(stack) > 0 {
 		stackData := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
+		if stackData == nil {
+			return nil, 0, fmt.Errorf("tree has a null node")
+		}
 		if stackData.Children == nil {
 			// leaf node.
+			if !isFinite(stackData.LeafValue) {
+				return nil, 0, fmt.Errorf("leaf value of node %d is not finite: %f", stackData.NodeID,
+					stackData.LeafValue)
+			}
 			node = &xgbNode{
 				NodeID:     stackData.NodeID,
 				Flags:      isLeaf,
 				LeafValues: stackData.LeafValue,
+				Cover:      stackData.Cover,
 			}
 		} else {
-			featIdx, err := convertFeatToIdx(featureMap, stackData.SplitFeatureID)
+			if len(stackData.Children) != 2 {
+				return nil, 0, fmt.Errorf("split node %d must have 2 children, got %d", stackData.NodeID,
+					len(stackData.Children))
+			}
+			// absent and 0 split conditions are told apart, producers omitting zero values would otherwise
+			// route every value of the feature as if split at 0.
+			threshold := 0.0
+			if stackData.SplitFeatureThreshold != nil {
+				threshold = *stackData.SplitFeatureThreshold
+			} else if stackData.SplitType != categoricalSplit {
+				return nil, 0, fmt.Errorf("split node %d has no split condition", stackData.NodeID)
+			}
+			if !isFinite(threshold) {
+				return nil, 0, fmt.Errorf("split condition of node %d is not finite: %f", stackData.NodeID,
+					threshold)
+			}
+			featIdx, err := convertFeatToIdx(featureMap, stackData.SplitFeatureID, fallback)
 			if err != nil {
 				return nil, 0, err
 			}
+			if featIdx < 0 || featIdx > maxIndex {
+				return nil, 0, fmt.Errorf("feature index %d of node %d is out of range", featIdx, stackData.NodeID)
+			}
 			if featIdx > maxFeatIdx {
 				maxFeatIdx = featIdx
 			}
 			node = &xgbNode{
 				NodeID:    stackData.NodeID,
-				Threshold: stackData.SplitFeatureThreshold,
+				Threshold: threshold,
 				No:        stackData.NoID,
 				Yes:       stackData.YesID,
 				Missing:   stackData.MissingID,
 				Feature:   featIdx,
+				Gain:      stackData.Gain,
+				Cover:     stackData.Cover,
+			}
+			if stackData.SplitType == categoricalSplit {
+				node.Flags |= isCategorical
+				node.Categories = make(map[int]struct{}, len(stackData.Categories))
+				for _, c := range stackData.Categories {
+					node.Categories[c] = struct{}{}
+				}
 			}
 			// find real length of the tree.
 			if maxDepth != 0 {
@@ -129,110 +425,700 @@ func buildTree(xgbTreeJSON *xgboostJSON, maxDepth int, featureMap map[string]int
 		}
 		if maxNumNodes > 0 {
 			if node.NodeID >= maxNumNodes {
-				return nil, 0, fmt.Errorf("wrong tree max depth %d, please check your model again for the"+
-					" correct parameter", maxDepth)
+				return nil, 0, fmt.Errorf("node id %d exceeds capacity for max depth %d, please check your model"+
+					" again for the correct parameter", node.NodeID, maxDepth)
+			}
+			if node.NodeID < 0 {
+				return nil, 0, fmt.Errorf("invalid node id %d", node.NodeID)
+			}
+			if t.nodes[node.NodeID] != nil {
+				return nil, 0, fmt.Errorf("duplicate node id %d", node.NodeID)
 			}
 			t.nodes[node.NodeID] = node
 		} else {
 			// do not know the depth beforehand just append.
+			if node.NodeID > maxIndex {
+				return nil, 0, fmt.Errorf("node id %d is out of range", node.NodeID)
+			}
 			t.nodes = append(t.nodes, node)
+			if node.NodeID > maxIdx {
+				maxIdx = node.NodeID
+			}
 		}
 	}
 	if maxDepth == 0 {
-		sort.SliceStable(t.nodes, func(i, j int) bool {
-			return t.nodes[i].NodeID < t.nodes[j].NodeID
-		})
+		// node ids are not guaranteed to be contiguous so place every node at its id.
+		nodes := make([]*xgbNode, maxIdx+1)
+		for _, n := range t.nodes {
+			if n.NodeID < 0 {
+				return nil, 0, fmt.Errorf("invalid node id %d", n.NodeID)
+			}
+			if nodes[n.NodeID] != nil {
+				return nil, 0, fmt.Errorf("duplicate node id %d", n.NodeID)
+			}
+			nodes[n.NodeID] = n
+		}
+		t.nodes = nodes
 	} else {
+		if maxIdx >= maxNumNodes {
+			// references past the capacity are reported as missing nodes below.
+			maxIdx = maxNumNodes - 1
+		}
 		t.nodes = t.nodes[:maxIdx+1]
 	}
+	if len(t.nodes) == 0 || t.nodes[0] == nil {
+		return nil, 0, fmt.Errorf("tree has no root node 0")
+	}
+	if err := validateNodeRefs(t); err != nil {
+		return nil, 0, err
+	}
+	if err := checkCycles(t); err != nil {
+		return nil, 0, err
+	}
 
 	return t, maxFeatIdx, nil
 }
 
-func LoadXGBoost(
+// validateNodeRefs checks that every child and missing reference of a split node points to an existing node.
+func validateNodeRefs(t *xgbTree) error {
+	exists := func(id int) bool {
+		return id >= 0 && id < len(t.nodes) && t.nodes[id] != nil
+	}
+	for _, n := range t.nodes {
+		if n == nil || n.Flags&isLeaf > 0 {
+			continue
+		}
+		for _, ref := range []int{n.Yes, n.No, n.Missing} {
+			if !exists(ref) {
+				return fmt.Errorf("node %d references missing node %d", n.NodeID, ref)
+			}
+		}
+	}
+	return nil
+}
+
+// checkCycles checks that no node of a tree reaches itself through its child and missing references, otherwise
+// traversal would never reach a leaf. References must point to existing nodes, see validateNodeRefs.
+func checkCycles(t *xgbTree) error {
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	type visit struct {
+		id   int
+		next int
+	}
+	state := make([]int, len(t.nodes))
+	for start, n := range t.nodes {
+		if n == nil || state[start] != unvisited {
+			continue
+		}
+		state[start] = onPath
+		path := []visit{{id: start}}
+		for len(path) > 0 {
+			v := &path[len(path)-1]
+			node := t.nodes[v.id]
+			if node.Flags&isLeaf > 0 || v.next == 3 {
+				state[v.id] = done
+				path = path[:len(path)-1]
+				continue
+			}
+			ref := [3]int{node.Yes, node.No, node.Missing}[v.next]
+			v.next++
+			switch state[ref] {
+			case onPath:
+				return fmt.Errorf("cycle at node %d: it references node %d, which is itself or one of its ancestors",
+					v.id, ref)
+			case unvisited:
+				state[ref] = onPath
+				path = append(path, visit{id: ref})
+			}
+		}
+	}
+	return nil
+}
+
+// checkMaxDepth checks the node ids of every tree fit in the capacity of maxDepth, otherwise it returns an error
+// suggesting the max depth of the model.
+func checkMaxDepth(xgbEnsembleJSON []*xgboostJSON, maxDepth int) error {
+	capacity := int(math.Pow(2, float64(maxDepth+1)) - 1)
+	modelDepth, maxID := 0, 0
+	type level struct {
+		node  *xgboostJSON
+		depth int
+	}
+	for _, root := range xgbEnsembleJSON {
+		stack := []level{{root, 0}}
+		for len(stack) > 0 {
+			l := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if l.node == nil {
+				continue
+			}
+			if l.depth > modelDepth {
+				modelDepth = l.depth
+			}
+			if l.node.NodeID > maxID {
+				maxID = l.node.NodeID
+			}
+			for _, c := range l.node.Children {
+				stack = append(stack, level{c, l.depth + 1})
+			}
+		}
+	}
+	if maxID > maxIndex {
+		return fmt.Errorf("node id %d is out of range", maxID)
+	}
+	if maxID >= capacity {
+		// pruned trees may have node ids above the capacity of their depth.
+		suggested := modelDepth
+		for int(math.Pow(2, float64(suggested+1))-1) <= maxID {
+			suggested++
+		}
+		return fmt.Errorf("max depth %d is too small for this model, node id %d exceeds capacity %d: "+
+			"trees have depth up to %d, please load with max depth %d or 0 to detect it",
+			maxDepth, maxID, capacity, modelDepth, suggested)
+	}
+	return nil
+}
+
+// buildTrees builds every tree of a json dump with the given number of workers, trees keep the dump order. It also
+// returns the maximum feature index used by the trees.
+func buildTrees(xgbEnsembleJSON []*xgboostJSON, maxDepth int, featureMap map[string]int,
+	fallback bool, workers int) ([]*xgbTree, int, error) {
+	nTrees := len(xgbEnsembleJSON)
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > nTrees {
+		workers = nTrees
+	}
+	trees := make([]*xgbTree, nTrees)
+	maxFeats := make([]int, nTrees)
+	errs := make([]error, nTrees)
+	var wg sync.WaitGroup
+	next := int64(-1)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= nTrees {
+					return
+				}
+				trees[i], maxFeats[i], errs[i] = buildTree(xgbEnsembleJSON[i], maxDepth, featureMap, fallback)
+			}
+		}()
+	}
+	wg.Wait()
+
+	maxFeat := 0
+	for i := range trees {
+		if errs[i] != nil {
+			return nil, 0, fmt.Errorf("error while reading %d tree: %w", i, errs[i])
+		}
+		if maxFeats[i] > maxFeat {
+			maxFeat = maxFeats[i]
+		}
+	}
+	return trees, maxFeat, nil
+}
+
+// treeToJSON converts the subtree rooted at node idx back to the nested json representation.
+func treeToJSON(t *xgbTree, idx int, featureNames map[int]string) (*xgboostJSON, error) {
+	if idx < 0 || idx >= len(t.nodes) || t.nodes[idx] == nil {
+		return nil, fmt.Errorf("cannot find node %d", idx)
+	}
+	node := t.nodes[idx]
+	if node.Flags&isLeaf > 0 {
+		return &xgboostJSON{NodeID: node.NodeID, LeafValue: node.LeafValues, Cover: node.Cover}, nil
+	}
+	feature := fmt.Sprintf("f%d", node.Feature)
+	if featureNames != nil {
+		feature = featureNames[node.Feature]
+	}
+	yes, err := treeToJSON(t, node.Yes, featureNames)
+	if err != nil {
+		return nil, err
+	}
+	no, err := treeToJSON(t, node.No, featureNames)
+	if err != nil {
+		return nil, err
+	}
+	threshold := node.Threshold
+	treeJSON := &xgboostJSON{
+		NodeID:                node.NodeID,
+		SplitFeatureID:        feature,
+		SplitFeatureThreshold: &threshold,
+		YesID:                 node.Yes,
+		NoID:                  node.No,
+		MissingID:             node.Missing,
+		Gain:                  node.Gain,
+		Cover:                 node.Cover,
+		Children:              []*xgboostJSON{yes, no},
+	}
+	if node.Flags&isCategorical > 0 {
+		treeJSON.SplitType = categoricalSplit
+		treeJSON.Categories = node.sortedCategories()
+	}
+	return treeJSON, nil
+}
+
+// DumpJSON writes the ensemble in the same json format as DMLC XGBoost dump_model API.
+func (e *xgbEnsemble) DumpJSON(w io.Writer) error {
+	trees := make([]*xgboostJSON, len(e.Trees))
+	for i, tree := range e.Trees {
+		treeJSON, err := treeToJSON(tree, 0, e.featureNames)
+		if err != nil {
+			return fmt.Errorf("error while dumping %d tree: %w", i, err)
+		}
+		trees[i] = treeJSON
+	}
+	return json.NewEncoder(w).Encode(trees)
+}
+
+// ToDOT writes the tree at treeIndex in Graphviz DOT format. Split nodes are labeled with the feature name if the
+// model is loaded with a feature map, the yes, no and missing edges are colored in blue, red and green.
+func (e *xgbEnsemble) ToDOT(treeIndex int, w io.Writer) error {
+	if treeIndex < 0 || treeIndex >= len(e.Trees) {
+		return fmt.Errorf("tree index %d out of range [0, %d)", treeIndex, len(e.Trees))
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph tree_%d {\n", treeIndex)
+	for _, node := range e.Trees[treeIndex].nodes {
+		if node == nil {
+			continue
+		}
+		if node.Flags&isLeaf > 0 {
+			fmt.Fprintf(bw, "\t%d [label=\"leaf=%g\", shape=ellipse];\n", node.NodeID, node.LeafValues)
+			continue
+		}
+		feature := fmt.Sprintf("f%d", node.Feature)
+		if e.featureNames != nil {
+			feature = e.featureNames[node.Feature]
+		}
+		label := fmt.Sprintf("%s<%g", feature, node.Threshold)
+		if node.Flags&isCategorical > 0 {
+			label = fmt.Sprintf("%s in %v", feature, node.sortedCategories())
+		}
+		fmt.Fprintf(bw, "\t%d [label=%q, shape=box];\n", node.NodeID, label)
+		fmt.Fprintf(bw, "\t%d -> %d [label=\"yes\", color=\"#0000FF\"];\n", node.NodeID, node.Yes)
+		fmt.Fprintf(bw, "\t%d -> %d [label=\"no\", color=\"#FF0000\"];\n", node.NodeID, node.No)
+		fmt.Fprintf(bw, "\t%d -> %d [label=\"missing\", color=\"#00AA00\", style=dashed];\n",
+			node.NodeID, node.Missing)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// loadXGBoostJSON builds the model of decoded dump_model json trees dumped with the feature map at featuresMapPath.
+func loadXGBoostJSON(
 	xgbEnsembleJSON []*xgboostJSON,
 	featuresMapPath string,
 	numClasses int,
 	maxDepth int,
-	activation activation.Activation) (*inference.Ensemble, error) {
-	var featMap map[string]int
-	var err error
-	if len(featuresMapPath) != 0 {
-		featMap, err = loadFeatureMap(featuresMapPath)
-		if err != nil {
-			return nil, err
+	activation activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	featMap, opts, err := loadFeatureMapFile(featuresMapPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return loadXGBoost(xgbEnsembleJSON, featMap, numClasses, maxDepth, activation, opts...)
+}
+
+func loadXGBoost(
+	xgbEnsembleJSON []*xgboostJSON,
+	featMap map[string]int,
+	numClasses int,
+	maxDepth int,
+	activation activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	options := newLoadOptions(opts)
+	if options.featureMap != nil {
+		if featMap != nil {
+			return nil, fmt.Errorf("feature map is set both as parameter and option")
 		}
+		featMap = options.featureMap
 	}
 
 	if maxDepth < 0 {
 		return nil, fmt.Errorf("max depth cannot be smaller than 0: %d", maxDepth)
 	}
+	if maxDepth > maxTreeDepth {
+		return nil, fmt.Errorf("max depth %d is too large, please load with max depth 0 to detect it", maxDepth)
+	}
 
 	nTrees := len(xgbEnsembleJSON)
 	if numClasses <= 0 {
 		return nil, fmt.Errorf("num class cannot be 0 or smaller: %d", numClasses)
 	}
+	if options.numParallelTree <= 0 {
+		return nil, fmt.Errorf("num parallel tree cannot be 0 or smaller: %d", options.numParallelTree)
+	}
 	if nTrees == 0 {
-		return nil, fmt.Errorf("no trees in file")
-	} else if nTrees%numClasses != 0 {
-		return nil, fmt.Errorf("wrong number of trees %d for number of class %d", nTrees, numClasses)
+		return nil, fmt.Errorf("%w in file", inference.ErrNoTrees)
+	} else if numClasses > nTrees {
+		return nil, fmt.Errorf("%w: number of classes %d exceeds tree count %d", inference.ErrClassMismatch,
+			numClasses, nTrees)
+	} else if nTrees%(numClasses*options.numParallelTree) != 0 {
+		return nil, fmt.Errorf("%w: wrong number of trees %d for number of class %d and %d parallel trees",
+			inference.ErrClassMismatch, nTrees, numClasses, options.numParallelTree)
+	}
+
+	if options.maxTrees < 0 {
+		return nil, fmt.Errorf("max trees cannot be smaller than 0: %d", options.maxTrees)
+	}
+	if options.maxTrees > 0 && options.maxTrees < nTrees {
+		if treesPerRound := numClasses * options.numParallelTree; options.maxTrees%treesPerRound != 0 {
+			return nil, fmt.Errorf("max trees %d must be a multiple of %d trees per boosting round", options.maxTrees,
+				treesPerRound)
+		}
+		if len(options.treeWeights) == nTrees {
+			options.treeWeights = options.treeWeights[:options.maxTrees]
+		}
+		xgbEnsembleJSON = xgbEnsembleJSON[:options.maxTrees]
+		nTrees = options.maxTrees
+	}
+
+	if options.treeWeights != nil && len(options.treeWeights) != nTrees {
+		return nil, fmt.Errorf("number of tree weights %d does not match number of trees %d",
+			len(options.treeWeights), nTrees)
+	}
+	for i, w := range options.treeWeights {
+		if !isFinite(w) {
+			return nil, fmt.Errorf("weight of %d tree is not finite: %f", i, w)
+		}
 	}
 
-	e := &xgbEnsemble{name: "xgboost", numClasses: numClasses}
-	e.Trees = make([]*xgbTree, 0, nTrees)
+	e := &xgbEnsemble{name: "xgboost", numClasses: numClasses, numParallelTree: options.numParallelTree}
+	e.treeWeights = options.treeWeights
+	e.quantileAlphas = options.quantileAlphas
+	e.variancePower = options.variancePower
+	e.featureTypes = options.featureTypes
+	e.objective = options.objective
+	var modelFeatMap map[string]int
+	if featMap == nil && options.featureNames != nil {
+		// split features of the trees are indices, the names only label them.
+		modelFeatMap = make(map[string]int, len(options.featureNames))
+		for idx, name := range options.featureNames {
+			if _, ok := modelFeatMap[name]; ok {
+				return nil, fmt.Errorf("duplicate feature name %s in model", name)
+			}
+			modelFeatMap[name] = idx
+		}
+	}
+	if featMap != nil || modelFeatMap != nil {
+		e.featureIndices = featMap
+		if featMap == nil {
+			e.featureIndices = modelFeatMap
+		}
+		e.featureNames = make(map[int]string, len(e.featureIndices))
+		for name, idx := range e.featureIndices {
+			e.featureNames[idx] = name
+		}
+	}
+	if maxDepth > 0 {
+		if err := checkMaxDepth(xgbEnsembleJSON, maxDepth); err != nil {
+			return nil, err
+		}
+	}
 	// TODO: Need to check if max feature index will be the last feature column.
 	// if it is not the case we should find another way to find the number of features.
-	maxFeat := 0
-	for i := 0; i < nTrees; i++ {
-		tree, numFeat, err := buildTree(xgbEnsembleJSON[i], maxDepth, featMap)
-		if err != nil {
-			return nil, fmt.Errorf("error while reading %d tree: %s", i, err.Error())
-		}
-		e.Trees = append(e.Trees, tree)
-		if numFeat > maxFeat {
-			maxFeat = numFeat
+	splitFeatMap := featMap
+	if options.positionalSplits {
+		splitFeatMap = nil
+	}
+	trees, maxFeat, err := buildTrees(xgbEnsembleJSON, maxDepth, splitFeatMap, options.featureMapFallback,
+		options.loadWorkers)
+	if err != nil {
+		return nil, err
+	}
+	e.Trees = trees
+	if options.checkFeatureMap && e.featureIndices != nil {
+		if err := e.validateFeatureMap(); err != nil {
+			return nil, err
 		}
 	}
 	e.numFeat = maxFeat + 1
+	if options.numFeatures > e.numFeat {
+		e.numFeat = options.numFeatures
+	}
+	e.flatten()
 
 	return &inference.Ensemble{EnsembleBase: e, Activation: activation}, nil
 }
 
-// LoadXGBoostFromJSON loads xgboost model from json file.
+// validateFeatureMap checks split features of every tree are within the bounds of the feature map.
+func (e *xgbEnsemble) validateFeatureMap() error {
+	for i, tree := range e.Trees {
+		for _, node := range tree.nodes {
+			if node == nil || node.Flags&isLeaf > 0 {
+				continue
+			}
+			if node.Feature < 0 || node.Feature >= len(e.featureIndices) {
+				return fmt.Errorf("feature %s of node %d in tree %d has index %d, out of bounds of feature map "+
+					"with %d features", e.featureNames[node.Feature], node.NodeID, i, node.Feature, len(e.featureIndices))
+			}
+		}
+	}
+	return nil
+}
+
+// loadFeatureMapFile loads the feature map file at featuresMapPath if set, it returns the feature indices and the
+// options with the feature types prepended.
+func loadFeatureMapFile(featuresMapPath string, opts []LoadOption) (map[string]int, []LoadOption, error) {
+	if len(featuresMapPath) == 0 {
+		return nil, opts, nil
+	}
+	features, err := loadFeatureInfo(featuresMapPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return featureIndices(features), append([]LoadOption{withFeatureTypes(featureTypes(features))}, opts...), nil
+}
+
+// LoadConfig describes how LoadXGBoost loads a model, zero values are the defaults.
+type LoadConfig struct {
+	// ModelPath is the path of the json model, generated by dump_model or save_model API and optionally gzip
+	// compressed.
+	ModelPath string
+	// FeatureMapPath is the path of the feature map used to dump the model, empty if the model uses default
+	// feature names.
+	FeatureMapPath string
+	// NumClasses is the number of classes, 1 for binary classification and regression. It can be 0 for save_model
+	// json to read it from the model.
+	NumClasses int
+	// MaxDepth is the maximum depth of the trees, 0 detects it from the node ids of each tree.
+	MaxDepth int
+	// Activation transforms raw predictions into scores.
+	Activation activation.Activation
+	// BaseScore overrides the margin added to the raw prediction of every class when set, dump_model json does not
+	// record it and save_model json does.
+	BaseScore *float64
+	// MaxTrees loads only the first MaxTrees trees when positive, see WithMaxTrees.
+	MaxTrees int
+	// StrictFields rejects unknown fields of dump_model json, see WithStrictFields.
+	StrictFields bool
+	// Options are applied after the options of the fields above.
+	Options []LoadOption
+}
+
+// LoadXGBoost loads xgboost model from json file as described by cfg, it is LoadXGBoostFromJSON with named
+// parameters.
+func LoadXGBoost(cfg LoadConfig) (*inference.Ensemble, error) {
+	var opts []LoadOption
+	if cfg.MaxTrees > 0 {
+		opts = append(opts, WithMaxTrees(cfg.MaxTrees))
+	}
+	if cfg.StrictFields {
+		opts = append(opts, WithStrictFields())
+	}
+	opts = append(opts, cfg.Options...)
+
+	featMap, opts, err := loadFeatureMapFile(cfg.FeatureMapPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	modelFile, err := os.Open(cfg.ModelPath)
+	if err != nil {
+		return nil, err
+	}
+	defer modelFile.Close()
+
+	ensemble, err := LoadXGBoostFromReader(modelFile, featMap, cfg.NumClasses, cfg.MaxDepth, cfg.Activation, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BaseScore != nil {
+		ensemble.BaseScore = *cfg.BaseScore
+	}
+	return ensemble, nil
+}
+
+// LoadXGBoostFromJSON loads xgboost model from json file. If maxDepth is 0, the tree depth is detected from
+// the node ids of each tree. The file is usually generated by dump_model API, a save_model json is also accepted in
+// which case numClasses can be 0 to read the number of classes from the model. See LoadXGBoost for the same with
+// named parameters.
 func LoadXGBoostFromJSON(
 	modelPath,
 	featuresMapPath string,
 	numClasses int,
 	maxDepth int,
-	activation activation.Activation) (*inference.Ensemble, error) {
-	modelFile, err := os.Open(modelPath)
+	activation activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	return LoadXGBoost(LoadConfig{
+		ModelPath:      modelPath,
+		FeatureMapPath: featuresMapPath,
+		NumClasses:     numClasses,
+		MaxDepth:       maxDepth,
+		Activation:     activation,
+		Options:        opts,
+	})
+}
+
+// LoadXGBoostFromReader loads xgboost model from a reader of json content, gzip compressed content is detected
+// and decompressed transparently. The feature map maps feature names to feature indices, pass nil if the model
+// uses default feature names. The reader is not closed, the caller keeps ownership of it, see
+// LoadXGBoostFromReadCloser to hand it over.
+//
+// Besides dump_model json, the content can be save_model json. The number of classes is then read from the model
+// when numClasses is 0 and must match it otherwise, the base score is read from the model as well. Split features of
+// save_model json are feature indices, so the feature map only names the features.
+func LoadXGBoostFromReader(
+	r io.Reader,
+	featureMap map[string]int,
+	numClasses int,
+	maxDepth int,
+	activation activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	modelReader, err := decompressReader(r)
 	if err != nil {
 		return nil, err
 	}
-	defer modelFile.Close()
+	br := bufio.NewReader(modelReader)
+	if isJSONObject(br) {
+		// save_model json, the number of classes can be read from the model.
+		var model struct {
+			saveModelJSON
+			// NodeID is only set by a dump_model tree passed without its enclosing array.
+			NodeID *int `json:"nodeid"`
+		}
+		if err := json.NewDecoder(br).Decode(&model); err != nil {
+			return nil, err
+		}
+		if model.NodeID != nil {
+			return nil, fmt.Errorf("expected an array of trees, got a single object")
+		}
+		if featureMap != nil {
+			opts = append([]LoadOption{WithFeatureMap(featureMap)}, opts...)
+		}
+		return loadSaveModel(&model.saveModelJSON, numClasses, maxDepth, activation, opts...)
+	}
+	if numClasses == 0 {
+		return nil, fmt.Errorf("number of classes is required for dump_model json, it is only read from " +
+			"save_model json")
+	}
 
+	xgbEnsembleJSON, err := decodeDumpJSON(br, newLoadOptions(opts).strictFields)
+	if err != nil {
+		return nil, err
+	}
+	return loadXGBoost(xgbEnsembleJSON, featureMap, numClasses, maxDepth, activation, opts...)
+}
+
+// decodeDumpJSON decodes the trees of a dump_model json, strict rejects unknown node fields.
+func decodeDumpJSON(r io.Reader, strict bool) ([]*xgboostJSON, error) {
 	var xgbEnsembleJSON []*xgboostJSON
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&xgbEnsembleJSON); err != nil {
+		return nil, err
+	}
+	return xgbEnsembleJSON, nil
+}
 
-	dec := json.NewDecoder(modelFile)
-	err = dec.Decode(&xgbEnsembleJSON)
+// readDumpFile reads the trees of a dump_model json file, optionally gzip compressed.
+func readDumpFile(modelPath string, strict bool) ([]*xgboostJSON, error) {
+	modelFile, err := os.Open(modelPath)
 	if err != nil {
 		return nil, err
 	}
-	return LoadXGBoost(xgbEnsembleJSON, featuresMapPath, numClasses, maxDepth, activation)
+	defer modelFile.Close()
+
+	modelReader, err := decompressReader(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(modelReader)
+	if isJSONObject(br) {
+		return nil, fmt.Errorf("%s is not a dump_model json", modelPath)
+	}
+	return decodeDumpJSON(br, strict)
 }
 
-func LoadXGBoostFromJSONBytes(
-	jsonBytes []byte,
+// LoadXGBoostFromJSONFiles loads xgboost model from several dump_model json files, for example one per boosting
+// round, as if their trees were dumped in a single file: trees are concatenated in the order of modelPaths and the
+// combined number of trees must suit numClasses. The other parameters are the same as LoadXGBoostFromJSON.
+func LoadXGBoostFromJSONFiles(
+	modelPaths []string,
 	featuresMapPath string,
 	numClasses int,
 	maxDepth int,
-	activation activation.Activation) (*inference.Ensemble, error) {
+	activation activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	if len(modelPaths) == 0 {
+		return nil, fmt.Errorf("no model file to load")
+	}
+	featMap, opts, err := loadFeatureMapFile(featuresMapPath, opts)
+	if err != nil {
+		return nil, err
+	}
 
+	strict := newLoadOptions(opts).strictFields
 	var xgbEnsembleJSON []*xgboostJSON
+	for _, modelPath := range modelPaths {
+		trees, err := readDumpFile(modelPath, strict)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading %s: %w", modelPath, err)
+		}
+		xgbEnsembleJSON = append(xgbEnsembleJSON, trees...)
+	}
+	return loadXGBoost(xgbEnsembleJSON, featMap, numClasses, maxDepth, activation, opts...)
+}
+
+// LoadXGBoostFromReadCloser is like LoadXGBoostFromReader but takes ownership of rc, for example an HTTP response
+// body: rc is always closed, also when loading fails. An error on close is returned only if loading succeeds.
+func LoadXGBoostFromReadCloser(
+	rc io.ReadCloser,
+	featureMap map[string]int,
+	numClasses int,
+	maxDepth int,
+	activation activation.Activation,
+	opts ...LoadOption) (ensemble *inference.Ensemble, err error) {
+	defer func() {
+		if closeErr := rc.Close(); closeErr != nil && err == nil {
+			ensemble, err = nil, closeErr
+		}
+	}()
+	return LoadXGBoostFromReader(rc, featureMap, numClasses, maxDepth, activation, opts...)
+}
 
-	err := json.Unmarshal(jsonBytes, &xgbEnsembleJSON)
+// isJSONObject reports whether the next non space character of r starts a json object, without consuming it.
+func isJSONObject(r *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return false
+		}
+		switch b[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// LoadXGBoostFromJSONBytes loads xgboost model from json content in memory, for example received over the network
+// or embedded in the binary. It accepts the same content and options as LoadXGBoostFromJSON, gzip compressed
+// content included.
+func LoadXGBoostFromJSONBytes(
+	jsonBytes []byte,
+	featuresMapPath string,
+	numClasses int,
+	maxDepth int,
+	activation activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	featMap, opts, err := loadFeatureMapFile(featuresMapPath, opts)
 	if err != nil {
 		return nil, err
 	}
-	return LoadXGBoost(xgbEnsembleJSON, featuresMapPath, numClasses, maxDepth, activation)
+	return LoadXGBoostFromReader(bytes.NewReader(jsonBytes), featMap, numClasses, maxDepth, activation, opts...)
 }
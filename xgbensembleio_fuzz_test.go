@@ -0,0 +1,53 @@
+//go:build go1.18
+// +build go1.18
+
+package xgboost
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// maxFuzzFeatures is the largest number of features of a fuzzed model predicted from a dense row.
+const maxFuzzFeatures = 1 << 16
+
+// FuzzLoadXGBoostFromJSON feeds arbitrary content to the reader loader, malformed models must be rejected with an
+// error, never with a panic, and loaded models must predict without panicking.
+func FuzzLoadXGBoostFromJSON(f *testing.F) {
+	for _, path := range []string{"test/data/iris_xgboost_dump.json", "test/data/iris_xgboost_save_model.json"} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+	f.Add([]byte("[" + twoLevelTreeJSON + "]"))
+	f.Add([]byte("[" + statsTreeJSON + "]"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, numClasses := range []int{1, 3} {
+			for _, maxDepth := range []int{0, 4} {
+				ensemble, err := LoadXGBoostFromReader(bytes.NewReader(data), nil, numClasses, maxDepth,
+					&activation.Raw{})
+				if err != nil {
+					continue
+				}
+				if _, err := ensemble.PredictSparse(mat.SparseVector{}); err != nil {
+					t.Fatalf("cannot predict with loaded model: %v", err)
+				}
+				if ensemble.NumFeatures() > maxFuzzFeatures {
+					// valid but too wide to allocate a dense row.
+					continue
+				}
+				if _, err := ensemble.PredictRow(make(mat.Vector, ensemble.NumFeatures())); err != nil {
+					t.Fatalf("cannot predict with loaded model: %v", err)
+				}
+			}
+		}
+	})
+}
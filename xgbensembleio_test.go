@@ -0,0 +1,1020 @@
+package xgboost
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/inference"
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+const twoLevelTreeJSON = `
+{ "nodeid": 0, "depth": 0, "split": "f2", "split_condition": 2.5, "yes": 1, "no": 2, "missing": 1, "children": [
+  { "nodeid": 1, "depth": 1, "split": "f0", "split_condition": 1.5, "yes": 3, "no": 4, "missing": 3, "children": [
+    { "nodeid": 3, "leaf": 0.1 },
+    { "nodeid": 4, "leaf": 0.2 }
+  ]},
+  { "nodeid": 2, "depth": 1, "split": "f1", "split_condition": 0.5, "yes": 5, "no": 6, "missing": 6, "children": [
+    { "nodeid": 5, "leaf": 0.3 },
+    { "nodeid": 6, "leaf": 0.4 }
+  ]}
+]}`
+
+// statsTreeJSON is a tree dumped with statistics.
+const statsTreeJSON = `
+{ "nodeid": 0, "depth": 0, "split": "f2", "split_condition": 2.5, "yes": 1, "no": 2, "missing": 1,
+  "gain": 12.5, "cover": 10, "children": [
+  { "nodeid": 1, "depth": 1, "split": "f0", "split_condition": 1.5, "yes": 3, "no": 4, "missing": 3,
+    "gain": 4, "cover": 6, "children": [
+    { "nodeid": 3, "leaf": 0.1, "cover": 4 },
+    { "nodeid": 4, "leaf": 0.2, "cover": 2 }
+  ]},
+  { "nodeid": 2, "depth": 1, "split": "f1", "split_condition": 0.5, "yes": 5, "no": 6, "missing": 6,
+    "gain": 2.5, "cover": 4, "children": [
+    { "nodeid": 5, "leaf": 0.3, "cover": 3 },
+    { "nodeid": 6, "leaf": 0.4, "cover": 1 }
+  ]}
+]}`
+
+func TestBuildTree_NodeFeatures(t *testing.T) {
+	var treeJSON xgboostJSON
+	err := json.Unmarshal([]byte(twoLevelTreeJSON), &treeJSON)
+	assert.NilError(t, err)
+
+	expectedFeatures := map[int]int{0: 2, 1: 0, 2: 1}
+	for _, maxDepth := range []int{0, 2} {
+		tree, maxFeat, err := buildTree(&treeJSON, maxDepth, nil, false)
+		assert.NilError(t, err)
+		assert.Equal(t, maxFeat, 2)
+		assert.Equal(t, len(tree.nodes), 7)
+		for _, node := range tree.nodes {
+			if node.Flags&isLeaf > 0 {
+				continue
+			}
+			assert.Equal(t, node.Feature, expectedFeatures[node.NodeID], "node %d", node.NodeID)
+		}
+	}
+}
+
+func TestLoadXGBoostFromJSON_TreeCount(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	data, err := ioutil.ReadFile(modelPath)
+	assert.NilError(t, err)
+	var trees []json.RawMessage
+	err = json.Unmarshal(data, &trees)
+	assert.NilError(t, err)
+
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	xgb, ok := ensemble.EnsembleBase.(*xgbEnsemble)
+	assert.Assert(t, ok)
+	assert.Equal(t, len(xgb.Trees), len(trees))
+}
+
+func TestLoadXGBoostFromJSONBytes_NumFeatures(t *testing.T) {
+	model := `[
+	{ "nodeid": 0, "depth": 0, "split": "f5", "split_condition": 0.5, "yes": 1, "no": 2, "missing": 1, "children": [
+	  { "nodeid": 1, "leaf": 0.1 },
+	  { "nodeid": 2, "depth": 1, "split": "f9", "split_condition": 1.5, "yes": 3, "no": 4, "missing": 3, "children": [
+	    { "nodeid": 3, "leaf": 0.2 },
+	    { "nodeid": 4, "leaf": 0.3 }
+	  ]}
+	]}]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumFeatures(), 10)
+}
+
+func TestLoadXGBoostFromReader(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/breast_cancer_xgboost_dump_fmap.json")
+	assert.NilError(t, err)
+	featMap, err := loadFeatureMap("test/data/breast_cancer_fmap.txt")
+	assert.NilError(t, err)
+
+	ensemble, err := LoadXGBoostFromReader(bytes.NewReader(data), featMap, 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix("test/data/breast_cancer_xgboost_true_prediction.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001)
+	assert.NilError(t, err)
+}
+
+// closeRecorder is a reader recording calls to Close, which returns err.
+type closeRecorder struct {
+	io.Reader
+	closed int
+	err    error
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed++
+	return c.err
+}
+
+func TestLoadXGBoostFromReadCloser(t *testing.T) {
+	// the decoder fails on truncated content.
+	body := &closeRecorder{Reader: strings.NewReader(`[{"nodeid": 0, "leaf": `)}
+	_, err := LoadXGBoostFromReadCloser(body, nil, 1, 0, &activation.Raw{})
+	assert.ErrorContains(t, err, "unexpected EOF")
+	assert.Equal(t, body.closed, 1)
+
+	body = &closeRecorder{Reader: strings.NewReader("[" + twoLevelTreeJSON + "]")}
+	ensemble, err := LoadXGBoostFromReadCloser(body, nil, 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	assert.Equal(t, body.closed, 1)
+	assert.Equal(t, ensemble.NumTrees(), 1)
+
+	body = &closeRecorder{Reader: strings.NewReader("[" + twoLevelTreeJSON + "]"), err: errors.New("broken pipe")}
+	ensemble, err = LoadXGBoostFromReadCloser(body, nil, 1, 0, &activation.Raw{})
+	assert.Error(t, err, "broken pipe")
+	assert.Assert(t, ensemble == nil)
+	assert.Equal(t, body.closed, 1)
+}
+
+func TestLoadXGBoostFromJSON_Gzip(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/iris_xgboost_dump.json")
+	assert.NilError(t, err)
+
+	dir, err := ioutil.TempDir("", "xgboost")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	modelPath := filepath.Join(dir, "iris_xgboost_dump.json.gz")
+	f, err := os.Create(modelPath)
+	assert.NilError(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(data)
+	assert.NilError(t, err)
+	assert.NilError(t, zw.Close())
+	assert.NilError(t, f.Close())
+
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix("test/data/iris_xgboost_true_prediction_proba.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001)
+	assert.NilError(t, err)
+}
+
+func TestLoadXGBoost_Config(t *testing.T) {
+	ensemble, err := LoadXGBoost(LoadConfig{
+		ModelPath:  "test/data/iris_xgboost_dump.json",
+		NumClasses: 3,
+		MaxDepth:   4,
+		Activation: &activation.Softmax{},
+	})
+	assert.NilError(t, err)
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix("test/data/iris_xgboost_true_prediction_proba.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001))
+
+	baseScore := 0.25
+	ensemble, err = LoadXGBoost(LoadConfig{
+		ModelPath:      "test/data/breast_cancer_xgboost_dump_fmap.json",
+		FeatureMapPath: "test/data/breast_cancer_fmap.txt",
+		NumClasses:     1,
+		Activation:     &activation.Logistic{},
+		BaseScore:      &baseScore,
+		MaxTrees:       6,
+		Options:        []LoadOption{WithFeatureMapValidation()},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumTrees(), 6)
+	assert.Equal(t, ensemble.BaseScore, 0.25)
+	assert.Assert(t, ensemble.FeatureNames() != nil)
+
+	// save_model json keeps its base score unless overridden.
+	saveModel, err := LoadXGBoost(LoadConfig{ModelPath: "test/data/iris_xgboost_save_model.json"})
+	assert.NilError(t, err)
+	assert.Equal(t, saveModel.NumClasses(), 3)
+	saveModel, err = LoadXGBoost(LoadConfig{ModelPath: "test/data/iris_xgboost_save_model.json", BaseScore: &baseScore})
+	assert.NilError(t, err)
+	assert.Equal(t, saveModel.BaseScore, 0.25)
+
+	modelPath := writeTempFile(t, "strict", `[{"nodeid": 0, "leaf": 0.5, "weight": 1}]`)
+	defer os.Remove(modelPath)
+	_, err = LoadXGBoost(LoadConfig{ModelPath: modelPath, NumClasses: 1, Activation: &activation.Raw{}})
+	assert.NilError(t, err)
+	_, err = LoadXGBoost(LoadConfig{ModelPath: modelPath, NumClasses: 1, Activation: &activation.Raw{},
+		StrictFields: true})
+	assert.ErrorContains(t, err, "unknown field")
+
+	_, err = LoadXGBoost(LoadConfig{ModelPath: "test/data/iris_xgboost_dump.json", NumClasses: 3, MaxTrees: 4,
+		Activation: &activation.Softmax{}})
+	assert.Error(t, err, "max trees 4 must be a multiple of 3 trees per boosting round")
+}
+
+func TestLoadXGBoostFromJSONFiles(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/iris_xgboost_dump.json")
+	assert.NilError(t, err)
+	var trees []json.RawMessage
+	assert.NilError(t, json.Unmarshal(data, &trees))
+
+	// split the boosting rounds of the 3 classes into two files.
+	split := len(trees) / 3 / 2 * 3
+	writeTrees := func(name string, trees []json.RawMessage) string {
+		data, err := json.Marshal(trees)
+		assert.NilError(t, err)
+		return writeTempFile(t, name, string(data))
+	}
+	first := writeTrees("first", trees[:split])
+	defer os.Remove(first)
+	second := writeTrees("second", trees[split:])
+	defer os.Remove(second)
+
+	ensemble, err := LoadXGBoostFromJSONFiles([]string{first, second}, "", 3, 0, &activation.Softmax{})
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumTrees(), len(trees))
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix("test/data/iris_xgboost_true_prediction_proba.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001))
+
+	// the combined number of trees is validated, not the number of each file.
+	odd := writeTrees("odd", trees[:split+1])
+	defer os.Remove(odd)
+	rest := writeTrees("rest", trees[split+1:])
+	defer os.Remove(rest)
+	_, err = LoadXGBoostFromJSONFiles([]string{odd, rest}, "", 3, 0, &activation.Softmax{})
+	assert.NilError(t, err)
+	_, err = LoadXGBoostFromJSONFiles([]string{odd}, "", 3, 0, &activation.Softmax{})
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+
+	_, err = LoadXGBoostFromJSONFiles(nil, "", 3, 0, &activation.Softmax{})
+	assert.Error(t, err, "no model file to load")
+	_, err = LoadXGBoostFromJSONFiles([]string{first, "test/data/iris_xgboost_save_model.json"}, "", 3, 0,
+		&activation.Softmax{})
+	assert.ErrorContains(t, err, "is not a dump_model json")
+}
+
+func TestLoadXGBoostFromJSONBytes_SingleTreeObject(t *testing.T) {
+	_, err := LoadXGBoostFromJSONBytes([]byte(twoLevelTreeJSON), "", 1, 0, &activation.Raw{})
+	assert.Error(t, err, "expected an array of trees, got a single object")
+	_, err = LoadXGBoostFromJSONBytes([]byte(`{"nodeid": 0, "leaf": 0.5}`), "", 1, 0, &activation.Raw{})
+	assert.Error(t, err, "expected an array of trees, got a single object")
+
+	// wrapped in an array the tree loads.
+	_, err = LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+}
+
+func TestBuildTree_NonContiguousNodeIDs(t *testing.T) {
+	treeJSON := &xgboostJSON{
+		NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 4, MissingID: 1,
+		Children: []*xgboostJSON{
+			{NodeID: 1, LeafValue: 0.1},
+			{NodeID: 4, LeafValue: 0.4},
+		},
+	}
+	tree, _, err := buildTree(treeJSON, 0, nil, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tree.nodes), 5)
+
+	p, err := tree.predict(mat.SparseVector{0: 1.0})
+	assert.NilError(t, err)
+	assert.Equal(t, p, 0.4)
+	p, err = tree.predict(mat.SparseVector{0: 0.0})
+	assert.NilError(t, err)
+	assert.Equal(t, p, 0.1)
+}
+
+func TestBuildTree_RootLeaf(t *testing.T) {
+	for _, maxDepth := range []int{0, 1, 4} {
+		tree, maxFeat, err := buildTree(&xgboostJSON{NodeID: 0, LeafValue: 0.7}, maxDepth, nil, false)
+		assert.NilError(t, err)
+		assert.Equal(t, maxFeat, 0)
+		assert.Equal(t, len(tree.nodes), 1)
+		assert.Equal(t, tree.nodes[0].Flags, uint8(isLeaf))
+		assert.Equal(t, tree.depth(0), 0)
+
+		p, err := tree.predict(mat.SparseVector{})
+		assert.NilError(t, err)
+		assert.Equal(t, p, 0.7)
+		p, err = tree.predictDense(mat.Vector{})
+		assert.NilError(t, err)
+		assert.Equal(t, p, 0.7)
+		p, err = newFlatTree(tree).predictDense(mat.Vector{})
+		assert.NilError(t, err)
+		assert.Equal(t, p, 0.7)
+	}
+
+	// a boosting round with a stump next to a regular tree.
+	stump := `{ "nodeid": 0, "leaf": 0.5, "cover": 10 }`
+	for _, maxDepth := range []int{0, 2} {
+		ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+statsTreeJSON+","+stump+"]"), "", 1, maxDepth,
+			&activation.Raw{})
+		assert.NilError(t, err)
+		assert.Equal(t, ensemble.NumFeatures(), 3)
+		features := mat.Vector{1.0, 0.0, 3.0}
+		pred, err := ensemble.PredictRow(features)
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(pred[0]-0.8) < 1e-12)
+		sparse, err := ensemble.PredictSparse(mat.SparseVector{0: 1.0, 1: 0.0, 2: 3.0})
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(sparse[0]-0.8) < 1e-12)
+		leaves, err := ensemble.PredictLeafIndices(features)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, leaves, []int{5, 0})
+
+		// the stump only adds to the bias term.
+		contribs, err := ensemble.PredictContribs(features)
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(contribs[3]-(0.21+0.5)) < 1e-12)
+		stats, err := ensemble.TreeStats()
+		assert.NilError(t, err)
+		assert.DeepEqual(t, stats[1], inference.TreeStat{Index: 1, Nodes: 1, Leaves: 1, Depth: 0})
+	}
+}
+
+func TestBuildTree_MalformedNodeIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		tree  *xgboostJSON
+		error string
+	}{
+		{
+			name: "missing child",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 3, LeafValue: 0.3}},
+			},
+			error: "node 0 references missing node 2",
+		},
+		{
+			name: "duplicate id",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 1, LeafValue: 0.2}},
+			},
+			error: "duplicate node id 1",
+		},
+		{
+			name: "duplicate split id",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{
+					{NodeID: 1, LeafValue: 0.1},
+					{NodeID: 0, SplitFeatureID: "f1", SplitFeatureThreshold: splitCondition(0.5), YesID: 3, NoID: 4, MissingID: 3,
+						Children: []*xgboostJSON{{NodeID: 3, LeafValue: 0.3}, {NodeID: 4, LeafValue: 0.4}}},
+				},
+			},
+			error: "duplicate node id 0",
+		},
+		{
+			name: "negative id",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: -2, MissingID: 1,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: -2, LeafValue: 0.2}},
+			},
+			error: "invalid node id -2",
+		},
+		{
+			name: "single child",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}},
+			},
+			error: "split node 0 must have 2 children, got 1",
+		},
+		{
+			name: "empty children",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{},
+			},
+			error: "split node 0 must have 2 children, got 0",
+		},
+		{
+			name: "self reference",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 0,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 2, LeafValue: 0.2}},
+			},
+			error: "cycle at node 0: it references node 0, which is itself or one of its ancestors",
+		},
+		{
+			name: "ancestor reference",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{
+					{NodeID: 1, SplitFeatureID: "f1", SplitFeatureThreshold: splitCondition(0.5), YesID: 3, NoID: 0, MissingID: 3,
+						Children: []*xgboostJSON{{NodeID: 3, LeafValue: 0.3}, {NodeID: 4, LeafValue: 0.4}}},
+					{NodeID: 2, LeafValue: 0.2},
+				},
+			},
+			error: "cycle at node 1: it references node 0, which is itself or one of its ancestors",
+		},
+		{
+			name: "null child",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2, MissingID: 1,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, nil},
+			},
+			error: "tree has a null node",
+		},
+		{
+			name:  "no root",
+			tree:  &xgboostJSON{NodeID: 3, LeafValue: 0.1},
+			error: "tree has no root node 0",
+		},
+		{
+			name: "reference past capacity",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 100, MissingID: 1,
+				Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 2, LeafValue: 0.2}},
+			},
+			error: "node 0 references missing node 100",
+		},
+		{
+			name: "feature index out of range",
+			tree: &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f9223372036854775807", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 2,
+				MissingID: 1, Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 2, LeafValue: 0.2}},
+			},
+			error: "feature index 9223372036854775807 of node 0 is out of range",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// nodes are placed by id with or without known depth.
+			for _, maxDepth := range []int{0, 2} {
+				_, _, err := buildTree(test.tree, maxDepth, nil, false)
+				assert.ErrorContains(t, err, test.error, "max depth %d", maxDepth)
+			}
+		})
+	}
+
+	// a huge node id fails without allocating a node per id.
+	huge := &xgboostJSON{
+		NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(0.5), YesID: 1, NoID: 1 << 40, MissingID: 1,
+		Children: []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 1 << 40, LeafValue: 0.2}},
+	}
+	_, _, err := buildTree(huge, 0, nil, false)
+	assert.Error(t, err, "node id 1099511627776 is out of range")
+	_, err = loadXGBoost([]*xgboostJSON{huge}, nil, 1, 4, &activation.Raw{})
+	assert.Error(t, err, "node id 1099511627776 is out of range")
+	_, err = loadXGBoost([]*xgboostJSON{huge}, nil, 1, 70, &activation.Raw{})
+	assert.Error(t, err, "max depth 70 is too large, please load with max depth 0 to detect it")
+
+	path := writeTempFile(t, "malformed*.json", `[{"nodeid": 0, "split": "f0", "split_condition": 0.5, "yes": 1,
+"no": 2, "missing": 1, "children": [{"nodeid": 1, "leaf": 0.1}, {"nodeid": 3, "leaf": 0.3}]}]`)
+	_, err = LoadXGBoostFromJSON(path, "", 1, 0, &activation.Raw{})
+	assert.ErrorContains(t, err, "error while reading 0 tree: node 0 references missing node 2")
+
+	// a cyclic tree fails to load instead of hanging at prediction.
+	for _, maxDepth := range []int{0, 2} {
+		_, err = LoadXGBoostFromJSONBytes([]byte(`[{"nodeid": 0, "split": "f0", "split_condition": 0.5, "yes": 0,
+"no": 2, "missing": 2, "children": [{"nodeid": 1, "leaf": 0.1}, {"nodeid": 2, "leaf": 0.2}]}]`), "", 1, maxDepth,
+			&activation.Raw{})
+		assert.ErrorContains(t, err, "error while reading 0 tree: cycle at node 0")
+	}
+}
+
+func TestBuildTree_NonFiniteValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		leaf      float64
+		error     string
+	}{
+		{name: "nan threshold", threshold: math.NaN(), error: "split condition of node 0 is not finite"},
+		{name: "inf threshold", threshold: math.Inf(1), error: "split condition of node 0 is not finite"},
+		{name: "inf leaf", threshold: 0.5, leaf: math.Inf(-1), error: "leaf value of node 2 is not finite"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			treeJSON := &xgboostJSON{
+				NodeID: 0, SplitFeatureID: "f0", SplitFeatureThreshold: splitCondition(test.threshold), YesID: 1, NoID: 2,
+				MissingID: 1,
+				Children:  []*xgboostJSON{{NodeID: 1, LeafValue: 0.1}, {NodeID: 2, LeafValue: test.leaf}},
+			}
+			_, _, err := buildTree(treeJSON, 0, nil, false)
+			assert.ErrorContains(t, err, test.error)
+		})
+	}
+}
+
+func TestBuildTree_SplitCondition(t *testing.T) {
+	// a split at 0 is a valid split, values below 0 go to yes.
+	model := `[{"nodeid": 0, "split": "f0", "split_condition": 0, "yes": 1, "no": 2, "missing": 2,
+"children": [{"nodeid": 1, "leaf": 0.1}, {"nodeid": 2, "leaf": 0.2}]}]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	for _, test := range []struct{ value, leaf float64 }{{-0.5, 0.1}, {0, 0.2}, {0.5, 0.2}} {
+		pred, err := ensemble.PredictRow(mat.Vector{test.value})
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], test.leaf)
+	}
+	// the split condition 0 is kept when the model is dumped again.
+	var buf bytes.Buffer
+	assert.NilError(t, ensemble.DumpJSON(&buf))
+	assert.Assert(t, strings.Contains(buf.String(), `"split_condition":0`), buf.String())
+	reloaded, err := LoadXGBoostFromJSONBytes(buf.Bytes(), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	pred, err := reloaded.PredictRow(mat.Vector{-0.5})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.1)
+
+	// a numerical split without split condition is rejected instead of split at 0.
+	_, err = LoadXGBoostFromJSONBytes([]byte(strings.Replace(model, `"split_condition": 0, `, "", 1)), "", 1, 0,
+		&activation.Raw{})
+	assert.ErrorContains(t, err, "split node 0 has no split condition")
+	// categorical splits route by categories and need none.
+	_, err = LoadXGBoostFromJSONBytes([]byte(strings.Replace(model, `"split_condition": 0, `,
+		`"split_type": 1, "categories": [1], `, 1)), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+}
+
+func TestConvertFeatToIdx(t *testing.T) {
+	tests := []struct {
+		feature string
+		idx     int
+		error   string
+	}{
+		{feature: "f12", idx: 12},
+		{feature: "12", idx: 12},
+		{feature: "feature_3", idx: 3},
+		{feature: "f0", idx: 0},
+		{feature: "feature", error: `cannot parse feature index from feature name "feature"`},
+		{feature: "f1a", error: `cannot parse feature index from feature name "f1a"`},
+		{feature: "", error: `cannot parse feature index from feature name ""`},
+	}
+	for _, test := range tests {
+		idx, err := convertFeatToIdx(nil, test.feature, false)
+		if test.error != "" {
+			assert.ErrorContains(t, err, test.error)
+			continue
+		}
+		assert.NilError(t, err, test.feature)
+		assert.Equal(t, idx, test.idx, test.feature)
+	}
+}
+
+func TestLoadXGBoostFromJSON_FeatureMapFallback(t *testing.T) {
+	// the root splits on a named feature, the other splits on default names.
+	named := []byte("[" + strings.Replace(twoLevelTreeJSON, `"f2"`, `"area"`, 1) + "]")
+	featureMap := map[string]int{"area": 2}
+	_, err := LoadXGBoostFromJSONBytes(named, "", 1, 0, &activation.Raw{}, WithFeatureMap(featureMap))
+	assert.Assert(t, errors.Is(err, inference.ErrFeatureNotFound), err)
+
+	ensemble, err := LoadXGBoostFromJSONBytes(named, "", 1, 0, &activation.Raw{}, WithFeatureMap(featureMap),
+		WithFeatureMapFallback())
+	assert.NilError(t, err)
+	expected, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumFeatures(), 3)
+	for _, features := range []mat.Vector{{1, 0, 3}, {2, 1, 1}, {1, 1, 1}, {2, 0, 3}} {
+		pred, err := ensemble.PredictRow(features)
+		assert.NilError(t, err)
+		expectedPred, err := expected.PredictRow(features)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, pred, expectedPred)
+	}
+
+	tests := []struct {
+		feature string
+		idx     int
+		error   string
+	}{
+		{feature: "area", idx: 2},
+		{feature: "f12", idx: 12},
+		// only default names fall back, other names may be typos.
+		{feature: "feature_3", error: "cannot find feature feature_3 in feature map"},
+		{feature: "f", error: "cannot find feature f in feature map"},
+		{feature: "f-1", error: "cannot find feature f-1 in feature map"},
+	}
+	for _, test := range tests {
+		idx, err := convertFeatToIdx(featureMap, test.feature, true)
+		if test.error != "" {
+			assert.Error(t, err, test.error)
+			continue
+		}
+		assert.NilError(t, err, test.feature)
+		assert.Equal(t, idx, test.idx, test.feature)
+	}
+}
+
+func TestLoadXGBoostFromJSON_MaxDepthTooSmall(t *testing.T) {
+	_, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 1, &activation.Softmax{})
+	assert.ErrorContains(t, err, "max depth 1 is too small for this model, node id 10 exceeds capacity 3: "+
+		"trees have depth up to 4, please load with max depth 4 or 0 to detect it")
+
+	// the suggested depth loads the model.
+	_, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+
+	// a pruned tree keeps node ids above the capacity of its depth.
+	pruned := `[{"nodeid": 0, "split": "f0", "split_condition": 0.5, "yes": 5, "no": 6, "missing": 5,
+"children": [{"nodeid": 5, "leaf": 0.1}, {"nodeid": 6, "leaf": 0.2}]}]`
+	_, err = LoadXGBoostFromJSONBytes([]byte(pruned), "", 1, 1, &activation.Raw{})
+	assert.ErrorContains(t, err, "trees have depth up to 1, please load with max depth 2 or 0")
+}
+
+func TestDumpJSON_RoundTrip(t *testing.T) {
+	tests := []struct {
+		modelPath  string
+		featureMap string
+		numClasses int
+	}{
+		{"test/data/iris_xgboost_dump.json", "", 3},
+		{"test/data/breast_cancer_xgboost_dump_fmap.json", "test/data/breast_cancer_fmap.txt", 1},
+	}
+	for _, tc := range tests {
+		ensemble, err := LoadXGBoostFromJSON(tc.modelPath, tc.featureMap, tc.numClasses, 4, &activation.Raw{})
+		assert.NilError(t, err)
+
+		var buf bytes.Buffer
+		err = ensemble.DumpJSON(&buf)
+		assert.NilError(t, err)
+
+		dumped, err := LoadXGBoostFromJSONBytes(buf.Bytes(), tc.featureMap, tc.numClasses, 4, &activation.Raw{})
+		assert.NilError(t, err)
+		assert.Assert(t, reflect.DeepEqual(ensemble.EnsembleBase, dumped.EnsembleBase), tc.modelPath)
+	}
+}
+
+func TestToDOT(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"), "", 1, 2, &activation.Raw{})
+	assert.NilError(t, err)
+
+	var buf bytes.Buffer
+	err = ensemble.ToDOT(0, &buf)
+	assert.NilError(t, err)
+	dot := buf.String()
+	assert.Check(t, strings.HasPrefix(dot, "digraph tree_0 {\n"))
+	assert.Check(t, strings.Contains(dot, "\t0 [label=\"f2<2.5\", shape=box];\n"))
+	assert.Check(t, strings.Contains(dot, "\t0 -> 1 [label=\"yes\", color=\"#0000FF\"];\n"))
+	assert.Check(t, strings.Contains(dot, "\t2 -> 6 [label=\"missing\", color=\"#00AA00\", style=dashed];\n"))
+	assert.Check(t, strings.Contains(dot, "\t6 [label=\"leaf=0.4\", shape=ellipse];\n"))
+	assert.Check(t, strings.HasSuffix(dot, "}\n"))
+
+	err = ensemble.ToDOT(1, &buf)
+	assert.ErrorContains(t, err, "out of range")
+}
+
+func TestLoadXGBoostFromJSON_WithFeatureMap(t *testing.T) {
+	featMap, err := loadFeatureMap("test/data/breast_cancer_fmap.txt")
+	assert.NilError(t, err)
+
+	modelPath := "test/data/breast_cancer_xgboost_dump_fmap.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "", 1, 4, &activation.Logistic{}, WithFeatureMap(featMap))
+	assert.NilError(t, err)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/breast_cancer_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+
+	expectedProb, err := mat.ReadCSVFileToDenseMatrix("test/data/breast_cancer_xgboost_true_prediction.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	err = mat.IsEqualMatrices(&predictions, &expectedProb, 0.0001)
+	assert.NilError(t, err)
+
+	_, err = LoadXGBoostFromJSON(modelPath, "test/data/breast_cancer_fmap.txt", 1, 4, &activation.Logistic{},
+		WithFeatureMap(featMap))
+	assert.ErrorContains(t, err, "feature map is set both")
+}
+
+func writeTempFile(t *testing.T, pattern, content string) string {
+	f, err := ioutil.TempFile("", pattern)
+	assert.NilError(t, err)
+	_, err = f.WriteString(content)
+	assert.NilError(t, err)
+	assert.NilError(t, f.Close())
+	return f.Name()
+}
+
+// splitCondition returns a pointer to a split condition for building xgboostJSON trees.
+func splitCondition(v float64) *float64 {
+	return &v
+}
+
+func TestLoadFeatureMap_NamesWithSpaces(t *testing.T) {
+	fmapPath := writeTempFile(t, "fmap", "0 user age bucket q\n1\tuser country\ti\n2 income q\n")
+	defer os.Remove(fmapPath)
+
+	featMap, err := loadFeatureMap(fmapPath)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, featMap, map[string]int{"user age bucket": 0, "user country": 1, "income": 2})
+
+	fmapPath = writeTempFile(t, "fmap", "0 income\n")
+	defer os.Remove(fmapPath)
+	_, err = loadFeatureMap(fmapPath)
+	assert.ErrorContains(t, err, "wrong feature map format")
+}
+
+func TestLoadXGBoost_IntFeatureType(t *testing.T) {
+	fmapPath := writeTempFile(t, "fmap", "0 count int\n1 ratio q\n")
+	defer os.Remove(fmapPath)
+
+	// DMLC XGBoost dumps the split count<3.5 of an int feature as count<4, save_model json keeps 3.5.
+	model := `[
+{ "nodeid": 0, "split": "count", "split_condition": %s, "yes": 1, "no": 2, "missing": 1, "children": [
+  { "nodeid": 1, "leaf": -1 },
+  { "nodeid": 2, "split": "ratio", "split_condition": 3.5, "yes": 3, "no": 4, "missing": 3, "children": [
+    { "nodeid": 3, "leaf": 1 },
+    { "nodeid": 4, "leaf": 2 }
+  ]}
+]}]`
+	tests := []struct {
+		threshold string
+		features  mat.Vector
+		expected  float64
+	}{
+		// integer values route the same with both thresholds.
+		{"4", mat.Vector{3, 0}, -1},
+		{"3.5", mat.Vector{3, 0}, -1},
+		{"4", mat.Vector{4, 0}, 1},
+		{"3.5", mat.Vector{4, 0}, 1},
+		// like DMLC XGBoost, other values are compared as floats whatever the feature type.
+		{"3.5", mat.Vector{3.4, 0}, -1},
+		{"3.5", mat.Vector{3.6, 0}, 1},
+		{"4", mat.Vector{3.9999999, 0}, -1},
+		{"3.5", mat.Vector{5, 3.4999999}, 1},
+		{"3.5", mat.Vector{5, 3.5}, 2},
+	}
+	for _, tc := range tests {
+		ensemble, err := LoadXGBoostFromJSONBytes([]byte(fmt.Sprintf(model, tc.threshold)), fmapPath, 1, 0,
+			&activation.Raw{})
+		assert.NilError(t, err)
+		types, err := ensemble.FeatureTypes()
+		assert.NilError(t, err)
+		assert.Equal(t, types[0], "int")
+		pred, err := ensemble.PredictRow(tc.features)
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], tc.expected, "threshold %s features %v", tc.threshold, tc.features)
+		pred, err = ensemble.PredictSparse(mat.SparseVector{0: tc.features[0], 1: tc.features[1]})
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], tc.expected, "threshold %s features %v", tc.threshold, tc.features)
+
+		// thresholds are kept as loaded.
+		tree, err := ensemble.Tree(0)
+		assert.NilError(t, err)
+		assert.Equal(t, fmt.Sprint(tree.Threshold(0)), tc.threshold)
+	}
+}
+
+func TestLoadFeatureMap_NoFinalNewLine(t *testing.T) {
+	fmapPath := writeTempFile(t, "fmap", "0 mean_radius q\r\n1 mean_texture q\n2 mean_perimeter q")
+	defer os.Remove(fmapPath)
+
+	featMap, err := loadFeatureMap(fmapPath)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, featMap, map[string]int{"mean_radius": 0, "mean_texture": 1, "mean_perimeter": 2})
+}
+
+func TestLoadXGBoost_SentinelErrors(t *testing.T) {
+	_, err := LoadXGBoostFromJSONBytes([]byte("[]"), "", 1, 0, &activation.Raw{})
+	assert.Assert(t, errors.Is(err, inference.ErrNoTrees), err)
+
+	_, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 4, 0, &activation.Softmax{})
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+	_, err = LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"), "", 2, 0, &activation.Softmax{})
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+	_, err = LoadXGBoostFromJSON("test/data/iris_xgboost_save_model.json", "", 2, 0, &activation.Softmax{})
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+
+	// the error of the tree is wrapped by the loader.
+	_, err = LoadXGBoostFromJSONBytes([]byte("["+twoLevelTreeJSON+"]"), "", 1, 0, &activation.Raw{},
+		WithFeatureMap(map[string]int{"f0": 0, "f2": 2}))
+	assert.Error(t, err, "error while reading 0 tree: cannot find feature f1 in feature map")
+	assert.Assert(t, errors.Is(err, inference.ErrFeatureNotFound), err)
+
+	_, err = LoadXGBoostFromJSON("test/data/missing.json", "", 1, 0, &activation.Raw{})
+	assert.Assert(t, errors.Is(err, os.ErrNotExist), err)
+	_, err = mat.ReadLibsvmFileToSparseMatrix("test/data/missing.libsvm")
+	assert.Assert(t, errors.Is(err, os.ErrNotExist), err)
+}
+
+func TestLoadFeatureMap_Empty(t *testing.T) {
+	for _, content := range []string{"", "\n  \n"} {
+		fmapPath := writeTempFile(t, "fmap", content)
+		defer os.Remove(fmapPath)
+
+		_, err := loadFeatureMap(fmapPath)
+		assert.ErrorContains(t, err, "empty feature map")
+		_, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", fmapPath, 3, 4, &activation.Softmax{})
+		assert.ErrorContains(t, err, "empty feature map "+fmapPath)
+	}
+}
+
+func TestLoadXGBoostFromJSON_FeatureTypes(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump_fmap.json",
+		"test/data/breast_cancer_fmap.txt", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	types, err := ensemble.FeatureTypes()
+	assert.NilError(t, err)
+	assert.Equal(t, len(types), 31)
+	assert.Equal(t, types[0], "q")
+	assert.Equal(t, types[30], "i")
+
+	ensemble, err = LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 4, &activation.Logistic{})
+	assert.NilError(t, err)
+	types, err = ensemble.FeatureTypes()
+	assert.NilError(t, err)
+	assert.Assert(t, types == nil)
+}
+
+// largeIrisModel returns the iris json dump with its trees repeated n times.
+func largeIrisModel(tb testing.TB, n int) []*xgboostJSON {
+	data, err := ioutil.ReadFile("test/data/iris_xgboost_dump.json")
+	assert.NilError(tb, err)
+	var trees []*xgboostJSON
+	assert.NilError(tb, json.Unmarshal(data, &trees))
+	model := make([]*xgboostJSON, 0, n*len(trees))
+	for i := 0; i < n; i++ {
+		model = append(model, trees...)
+	}
+	return model
+}
+
+func TestLoadXGBoost_ConcurrentLoadingMatchesSerial(t *testing.T) {
+	model := largeIrisModel(t, 20)
+	serial, err := loadXGBoostJSON(model, "", 3, 4, &activation.Softmax{}, WithLoadConcurrency(1))
+	assert.NilError(t, err)
+	concurrent, err := loadXGBoostJSON(model, "", 3, 4, &activation.Softmax{}, WithLoadConcurrency(8))
+	assert.NilError(t, err)
+	assert.Assert(t, reflect.DeepEqual(serial.EnsembleBase, concurrent.EnsembleBase))
+
+	var serialJSON, concurrentJSON bytes.Buffer
+	assert.NilError(t, serial.DumpJSON(&serialJSON))
+	assert.NilError(t, concurrent.DumpJSON(&concurrentJSON))
+	assert.Assert(t, bytes.Equal(serialJSON.Bytes(), concurrentJSON.Bytes()))
+}
+
+func TestLoadXGBoost_ConcurrentLoadingError(t *testing.T) {
+	model := largeIrisModel(t, 2)
+	model[7] = &xgboostJSON{NodeID: 0, SplitFeatureID: "x", YesID: 1, NoID: 2, MissingID: 1,
+		Children: []*xgboostJSON{{NodeID: 1}, {NodeID: 2}}}
+	_, err := loadXGBoostJSON(model, "", 3, 4, &activation.Softmax{}, WithLoadConcurrency(4))
+	assert.ErrorContains(t, err, "error while reading 7 tree")
+}
+
+func BenchmarkLoadXGBoost_Serial(b *testing.B) {
+	model := largeIrisModel(b, 170)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := loadXGBoostJSON(model, "", 3, 4, &activation.Softmax{}, WithLoadConcurrency(1))
+		assert.NilError(b, err)
+	}
+}
+
+func BenchmarkLoadXGBoost_Concurrent(b *testing.B) {
+	model := largeIrisModel(b, 170)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := loadXGBoostJSON(model, "", 3, 4, &activation.Softmax{})
+		assert.NilError(b, err)
+	}
+}
+
+func TestLoadXGBoost_NumClassesExceedsTreeCount(t *testing.T) {
+	// first boosting round of the iris model, 1 tree per class.
+	model := largeIrisModel(t, 1)[:3]
+	_, err := loadXGBoostJSON(model, "", 10, 4, &activation.Softmax{})
+	assert.ErrorContains(t, err, "number of classes 10 exceeds tree count 3")
+}
+
+func TestLoadXGBoostFromJSONBytes(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_dump_fmap.json"
+	fmapPath := "test/data/breast_cancer_fmap.txt"
+	fromFile, err := LoadXGBoostFromJSON(modelPath, fmapPath, 1, 0, &activation.Logistic{})
+	assert.NilError(t, err)
+
+	data, err := ioutil.ReadFile(modelPath)
+	assert.NilError(t, err)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err = zw.Write(data)
+	assert.NilError(t, err)
+	assert.NilError(t, zw.Close())
+
+	for _, content := range [][]byte{data, compressed.Bytes()} {
+		fromBytes, err := LoadXGBoostFromJSONBytes(content, fmapPath, 1, 0, &activation.Logistic{})
+		assert.NilError(t, err)
+		assert.Assert(t, reflect.DeepEqual(fromFile.EnsembleBase, fromBytes.EnsembleBase))
+	}
+
+	_, err = LoadXGBoostFromJSONBytes(data, fmapPath, 1, -1, &activation.Logistic{})
+	assert.ErrorContains(t, err, "max depth cannot be smaller than 0")
+}
+
+func TestLoadXGBoost_FeatureMapValidation(t *testing.T) {
+	model := []byte("[" + twoLevelTreeJSON + "]")
+	// f2 is mapped outside a 3 features map.
+	featureMap := map[string]int{"f0": 0, "f1": 1, "f2": 45}
+	_, err := LoadXGBoostFromJSONBytes(model, "", 1, 0, &activation.Raw{}, WithFeatureMap(featureMap),
+		WithFeatureMapValidation())
+	assert.ErrorContains(t, err, "feature f2 of node 0 in tree 0 has index 45, out of bounds of feature map "+
+		"with 3 features")
+
+	// without validation the model loads.
+	_, err = LoadXGBoostFromJSONBytes(model, "", 1, 0, &activation.Raw{}, WithFeatureMap(featureMap))
+	assert.NilError(t, err)
+
+	_, err = LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump_fmap.json", "test/data/breast_cancer_fmap.txt",
+		1, 0, &activation.Logistic{}, WithFeatureMapValidation())
+	assert.NilError(t, err)
+}
+
+func TestLoadXGBoost_MaxTrees(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump.json"
+	full, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{}, WithMaxTrees(3))
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumTrees(), 3)
+
+	// the first 3 trees are the first boosting round.
+	for _, row := range irisDenseInput(t).Vectors {
+		pred, err := ensemble.PredictRow(*row)
+		assert.NilError(t, err)
+		expected, err := full.PredictWithLimit(*row, 1)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualVectors(&pred, &expected, 1e-12))
+	}
+
+	// a limit above the number of trees loads all trees.
+	ensemble, err = LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{}, WithMaxTrees(300))
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumTrees(), 30)
+	_, err = LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{}, WithMaxTrees(4))
+	assert.Error(t, err, "max trees 4 must be a multiple of 3 trees per boosting round")
+	_, err = LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{}, WithMaxTrees(-1))
+	assert.Error(t, err, "max trees cannot be smaller than 0: -1")
+
+	// weights of DART trees are cut too.
+	dart, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{}, WithMaxTrees(6),
+		WithTreeWeights(make([]float64, 30)))
+	assert.NilError(t, err)
+	assert.Equal(t, dart.NumTrees(), 6)
+}
+
+func TestLoadXGBoostFromJSON_StrictFields(t *testing.T) {
+	// dumps of DMLC XGBoost only have known fields.
+	tests := []struct {
+		modelPath  string
+		fmapPath   string
+		numClasses int
+	}{
+		{"test/data/iris_xgboost_dump.json", "", 3},
+		{"test/data/breast_cancer_xgboost_dump.json", "", 1},
+		{"test/data/breast_cancer_xgboost_dump_fmap.json", "test/data/breast_cancer_fmap.txt", 1},
+	}
+	for _, tc := range tests {
+		_, err := LoadXGBoostFromJSON(tc.modelPath, tc.fmapPath, tc.numClasses, 0, &activation.Raw{},
+			WithStrictFields())
+		assert.NilError(t, err, tc.modelPath)
+	}
+
+	categorical := `[
+	{ "nodeid": 0, "depth": 0, "split": "f0", "split_type": 1, "categories": [1, 3], "yes": 1, "no": 2, "missing": 2,
+	  "children": [{ "nodeid": 1, "leaf": 0.5 }, { "nodeid": 2, "leaf": -0.5 }]}]`
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(categorical), "", 1, 0, &activation.Raw{}, WithStrictFields())
+	assert.NilError(t, err)
+	pred, err := ensemble.PredictRow(mat.Vector{3})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 0.5)
+
+	// a dump with node fields not supported by this package loads only without strict mode.
+	unsupported := strings.Replace(categorical, `"split_type": 1, "categories": [1, 3]`,
+		`"split_condition": 0.5, "split_kind": "partition"`, 1)
+	_, err = LoadXGBoostFromJSONBytes([]byte(unsupported), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	_, err = LoadXGBoostFromJSONBytes([]byte(unsupported), "", 1, 0, &activation.Raw{}, WithStrictFields())
+	assert.Error(t, err, `json: unknown field "split_kind"`)
+}
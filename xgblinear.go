@@ -0,0 +1,84 @@
+package xgboost
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// xgbLinear is a gblinear booster, the raw prediction of every class is a dot product of the features with the class
+// weights plus the class bias.
+type xgbLinear struct {
+	// weights are feature major like in DMLC XGBoost: the weight of feature f for class c is weights[f*numClasses+c].
+	weights    []float64
+	bias       []float64
+	numClasses int
+	numFeat    int
+	objective  string
+}
+
+// Name returns name of linear model.
+func (l *xgbLinear) Name() string {
+	return "gblinear"
+}
+
+// NumClasses returns number of classes for this linear model.
+func (l *xgbLinear) NumClasses() int {
+	return l.numClasses
+}
+
+// NumFeatures returns number of features this linear model expects.
+func (l *xgbLinear) NumFeatures() int {
+	return l.numFeat
+}
+
+// Objective returns the objective name of this linear model, it is empty if the model does not record it.
+func (l *xgbLinear) Objective() string {
+	return l.objective
+}
+
+// PredictInner returns prediction of this linear model for a sparse feature vector, absent features, NaN values and
+// features unknown to the model do not contribute.
+func (l *xgbLinear) PredictInner(features mat.SparseVector) (mat.Vector, error) {
+	pred := make(mat.Vector, l.numClasses)
+	copy(pred, l.bias)
+	for f, v := range features {
+		if f < 0 || f >= l.numFeat || math.IsNaN(v) {
+			continue
+		}
+		for c := range pred {
+			pred[c] += v * l.weights[f*l.numClasses+c]
+		}
+	}
+	return pred, nil
+}
+
+// PredictInnerDense writes raw prediction of this linear model for a dense feature vector into predictions, NaN
+// values are missing and do not contribute.
+func (l *xgbLinear) PredictInnerDense(features mat.Vector, predictions mat.Vector) error {
+	if len(predictions) != l.numClasses {
+		return fmt.Errorf("predictions length (%d) must match number of classes (%d)", len(predictions), l.numClasses)
+	}
+	copy(predictions, l.bias)
+	for f, v := range features[:l.numFeat] {
+		if math.IsNaN(v) {
+			continue
+		}
+		for c := range predictions {
+			predictions[c] += v * l.weights[f*l.numClasses+c]
+		}
+	}
+	return nil
+}
+
+// Summary returns a one line description of the weights of this linear model.
+func (l *xgbLinear) Summary() string {
+	return fmt.Sprintf("weights=%d", len(l.weights))
+}
+
+// ApproxMemoryBytes estimates the memory held by this linear model.
+func (l *xgbLinear) ApproxMemoryBytes() int {
+	return int(unsafe.Sizeof(*l)) + 8*(len(l.weights)+len(l.bias))
+}
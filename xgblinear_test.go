@@ -0,0 +1,109 @@
+package xgboost
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/inference"
+	"github.com/Elvenson/xgboost-go/mat"
+	"github.com/Elvenson/xgboost-go/protobuf"
+)
+
+// multiclassLinearDumpJSON is a dump_model json of a gblinear booster with 2 features and 3 classes, weights are
+// feature major.
+const multiclassLinearDumpJSON = `[
+  { "bias": [
+      0.1,
+      0.2,
+      -0.3
+    ],
+    "weight": [
+      1,
+      -1,
+      0.5,
+      2,
+      0,
+      -0.25
+    ]
+  }
+]`
+
+func TestLoadXGBoostLinearFromJSON(t *testing.T) {
+	modelPath := writeTempFile(t, "linear", multiclassLinearDumpJSON)
+	defer os.Remove(modelPath)
+
+	for _, numClasses := range []int{0, 3} {
+		ensemble, err := LoadXGBoostLinearFromJSON(modelPath, numClasses, &activation.Softmax{})
+		assert.NilError(t, err)
+		assert.Equal(t, ensemble.Name(), "gblinear")
+		assert.Equal(t, ensemble.NumClasses(), 3)
+		assert.Equal(t, ensemble.NumFeatures(), 2)
+		assert.Assert(t, ensemble.ApproxMemoryBytes() > 9*8)
+
+		margin, err := ensemble.PredictMargin(mat.Vector{2, 3})
+		assert.NilError(t, err)
+		expected := mat.Vector{0.1 + 2*1 + 3*2, 0.2 - 2, -0.3 + 2*0.5 - 3*0.25}
+		assert.NilError(t, mat.IsEqualVectors(&margin, &expected, 1e-12))
+
+		// missing features do not contribute.
+		margin, err = ensemble.PredictMargin(mat.Vector{math.NaN(), 3})
+		assert.NilError(t, err)
+		expected = mat.Vector{0.1 + 3*2, 0.2, -0.3 - 3*0.25}
+		assert.NilError(t, mat.IsEqualVectors(&margin, &expected, 1e-12))
+		pred, err := ensemble.PredictSparse(mat.SparseVector{1: 3, 7: 100})
+		assert.NilError(t, err)
+		p, err := (&activation.Softmax{}).Transform(expected)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualVectors(&pred, &p, 1e-12))
+	}
+
+	_, err := LoadXGBoostLinearFromJSON(modelPath, 2, &activation.Softmax{})
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+	_, err = LoadXGBoostLinearFromReader(strings.NewReader(`{"bias": [0.1], "weight": [1, NaN]}`), 1, nil)
+	assert.ErrorContains(t, err, "invalid character")
+	_, err = LoadXGBoostLinearFromReader(strings.NewReader(`[]`), 1, nil)
+	assert.Error(t, err, "expected 1 linear booster, got 0")
+	_, err = LoadXGBoostLinearFromReader(strings.NewReader(`{"learner": {"gradient_booster": {"name": "gbtree"}}}`),
+		0, nil)
+	assert.Error(t, err, "expected gblinear booster, got gbtree")
+}
+
+func TestLoadXGBoostLinearFromSaveModelJSON(t *testing.T) {
+	// weights of the 3 features followed by the bias.
+	model := `{"learner": {
+		"gradient_booster": {"name": "gblinear", "model": {"weights": [0.5, -1, 0.25, 0.1]}},
+		"learner_model_param": {"base_score": "2.5E-1", "num_class": "0", "num_feature": "3"},
+		"objective": {"name": "binary:logistic"}}}`
+
+	linear, err := LoadXGBoostLinearFromReader(strings.NewReader(model), 0, nil)
+	assert.NilError(t, err)
+	// the save_model loaders accept gblinear boosters too.
+	saveModel, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+	assert.NilError(t, err)
+
+	for _, ensemble := range []*inference.Ensemble{linear, saveModel} {
+		assert.Equal(t, ensemble.NumClasses(), 1)
+		assert.Equal(t, ensemble.NumFeatures(), 3)
+		assert.Equal(t, ensemble.Type(), protobuf.ActivateType_LOGISTIC)
+		assert.Assert(t, math.Abs(ensemble.BaseScore-math.Log(0.25/0.75)) < 1e-12)
+		assert.Equal(t, ensemble.Summary(), "gblinear: classes=1 features=3 activation=LOGISTIC weights=3")
+
+		pred, err := ensemble.PredictRow(mat.Vector{2, 1, 4})
+		assert.NilError(t, err)
+		margin := math.Log(0.25/0.75) + 0.1 + 2*0.5 - 1 + 4*0.25
+		assert.Assert(t, math.Abs(pred[0]-1/(1+math.Exp(-margin))) < 1e-12)
+	}
+
+	_, err = LoadXGBoostLinearFromReader(strings.NewReader(strings.Replace(model, `"num_feature": "3"`,
+		`"num_feature": "4"`, 1)), 0, nil)
+	assert.Error(t, err, "model has weights for 3 features, expected num_feature 4")
+	_, err = LoadXGBoostLinearFromReader(strings.NewReader(strings.Replace(model, `"num_class": "0"`,
+		`"num_class": "3"`, 1)), 0, nil)
+	assert.Assert(t, errors.Is(err, inference.ErrClassMismatch), err)
+}
@@ -0,0 +1,163 @@
+package xgboost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/inference"
+)
+
+// linearDumpJSON is the json model of a gblinear booster written by DMLC XGBoost dump_model API.
+type linearDumpJSON struct {
+	Bias   []float64 `json:"bias"`
+	Weight []float64 `json:"weight"`
+}
+
+// newLinear builds a linear model from feature major weights and one bias per class.
+func newLinear(weights []float64, bias []float64, numClasses int) (*xgbLinear, error) {
+	if numClasses <= 0 {
+		return nil, fmt.Errorf("num class cannot be 0 or smaller: %d", numClasses)
+	}
+	if len(bias) != numClasses {
+		return nil, fmt.Errorf("%w: %d biases for %d classes", inference.ErrClassMismatch, len(bias), numClasses)
+	}
+	if len(weights)%numClasses != 0 {
+		return nil, fmt.Errorf("%w: %d weights is not a multiple of %d classes", inference.ErrClassMismatch,
+			len(weights), numClasses)
+	}
+	for i, w := range weights {
+		if !isFinite(w) {
+			return nil, fmt.Errorf("weight %d is not finite: %f", i, w)
+		}
+	}
+	for c, b := range bias {
+		if !isFinite(b) {
+			return nil, fmt.Errorf("bias of class %d is not finite: %f", c, b)
+		}
+	}
+	return &xgbLinear{weights: weights, bias: bias, numClasses: numClasses, numFeat: len(weights) / numClasses}, nil
+}
+
+// loadLinearSaveModel builds the linear model of a save_model json, the weights hold the feature major weights
+// followed by one bias per class.
+func loadLinearSaveModel(learner *learnerJSON, numClasses int, act activation.Activation) (*inference.Ensemble,
+	error) {
+	numClasses, err := learner.numClasses(numClasses)
+	if err != nil {
+		return nil, err
+	}
+	weights := learner.GradientBooster.Model.Weights
+	if len(weights) < numClasses {
+		return nil, fmt.Errorf("%w: %d weights for %d classes", inference.ErrClassMismatch, len(weights),
+			numClasses)
+	}
+	numWeights := len(weights) - numClasses
+	linear, err := newLinear(weights[:numWeights], weights[numWeights:], numClasses)
+	if err != nil {
+		return nil, err
+	}
+	numFeatures, err := parseIntParam("num_feature", learner.LearnerModelParam.NumFeature, linear.numFeat)
+	if err != nil {
+		return nil, err
+	}
+	if numFeatures != linear.numFeat {
+		return nil, fmt.Errorf("model has weights for %d features, expected num_feature %d", linear.numFeat,
+			numFeatures)
+	}
+	baseScore, err := learner.baseScore()
+	if err != nil {
+		return nil, err
+	}
+	linear.objective = learner.Objective.Name
+	margin, err := baseMargin(linear.objective, baseScore)
+	if err != nil {
+		return nil, err
+	}
+
+	if act == nil {
+		act = activation.FromObjective(linear.objective)
+	}
+	return &inference.Ensemble{
+		EnsembleBase: linear,
+		Activation:   act,
+		BaseScore:    margin,
+	}, nil
+}
+
+// LoadXGBoostLinearFromJSON loads a gblinear model from json file, generated either by dump_model or by save_model
+// API. The raw prediction of a class is the dot product of the features with the class weights plus the class bias,
+// missing features do not contribute.
+//
+// For dump_model json, numClasses can be 0 to read it from the number of biases and a nil activation is raw
+// activation, the base score of the model is not recorded and must be set on the returned model. For save_model json,
+// numClasses can be 0 as well and a nil activation is picked from the objective, the base score is read from the
+// model.
+func LoadXGBoostLinearFromJSON(modelPath string, numClasses int, activation activation.Activation) (
+	*inference.Ensemble, error) {
+	modelFile, err := os.Open(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	defer modelFile.Close()
+
+	return LoadXGBoostLinearFromReader(modelFile, numClasses, activation)
+}
+
+// LoadXGBoostLinearFromReader loads a gblinear model from a reader of json content, see LoadXGBoostLinearFromJSON.
+// Gzip compressed content is detected and decompressed transparently. The reader is not closed.
+func LoadXGBoostLinearFromReader(r io.Reader, numClasses int, act activation.Activation) (*inference.Ensemble,
+	error) {
+	modelReader, err := decompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(modelReader)
+	if err != nil {
+		return nil, err
+	}
+
+	var dump linearDumpJSON
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		// dump_model json holds a single booster.
+		var dumps []linearDumpJSON
+		if err := json.Unmarshal(data, &dumps); err != nil {
+			return nil, err
+		}
+		if len(dumps) != 1 {
+			return nil, fmt.Errorf("expected 1 linear booster, got %d", len(dumps))
+		}
+		dump = dumps[0]
+	} else {
+		var model struct {
+			linearDumpJSON
+			Learner *learnerJSON `json:"learner"`
+		}
+		if err := json.Unmarshal(data, &model); err != nil {
+			return nil, err
+		}
+		if model.Learner != nil {
+			if model.Learner.GradientBooster.Name != "gblinear" {
+				return nil, fmt.Errorf("expected gblinear booster, got %s", model.Learner.GradientBooster.Name)
+			}
+			return loadLinearSaveModel(model.Learner, numClasses, act)
+		}
+		dump = model.linearDumpJSON
+	}
+
+	if numClasses == 0 {
+		numClasses = len(dump.Bias)
+	}
+	linear, err := newLinear(dump.Weight, dump.Bias, numClasses)
+	if err != nil {
+		return nil, err
+	}
+	if act == nil {
+		act = &activation.Raw{}
+	}
+	return &inference.Ensemble{EnsembleBase: linear, Activation: act}, nil
+}
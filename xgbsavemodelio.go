@@ -0,0 +1,465 @@
+package xgboost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/inference"
+)
+
+// saveModelJSON is the json model written by DMLC XGBoost save_model API.
+type saveModelJSON struct {
+	Learner learnerJSON `json:"learner"`
+}
+
+type learnerJSON struct {
+	GradientBooster   gradientBoosterJSON   `json:"gradient_booster"`
+	LearnerModelParam learnerModelParamJSON `json:"learner_model_param"`
+	Objective         objectiveJSON         `json:"objective"`
+	FeatureNames      []string              `json:"feature_names"`
+	FeatureTypes      []string              `json:"feature_types"`
+}
+
+type gradientBoosterJSON struct {
+	Name  string     `json:"name"`
+	Model gbTreeJSON `json:"model"`
+	// GBTree and WeightDrop are only set for DART boosters, which wrap a gbtree booster.
+	GBTree     *gradientBoosterJSON `json:"gbtree"`
+	WeightDrop []float64            `json:"weight_drop"`
+}
+
+type gbTreeJSON struct {
+	GBTreeModelParam gbTreeModelParamJSON `json:"gbtree_model_param"`
+	Trees            []*saveModelTreeJSON `json:"trees"`
+	TreeInfo         []int                `json:"tree_info"`
+	// Weights is only set for gblinear boosters.
+	Weights []float64 `json:"weights"`
+}
+
+type gbTreeModelParamJSON struct {
+	NumParallelTree string `json:"num_parallel_tree"`
+	NumTrees        string `json:"num_trees"`
+}
+
+type learnerModelParamJSON struct {
+	BaseScore  string `json:"base_score"`
+	NumClass   string `json:"num_class"`
+	NumFeature string `json:"num_feature"`
+	NumTarget  string `json:"num_target"`
+}
+
+type objectiveJSON struct {
+	Name                   string                     `json:"name"`
+	QuantileLossParam      quantileLossParamJSON      `json:"quantile_loss_param"`
+	TweedieRegressionParam tweedieRegressionParamJSON `json:"tweedie_regression_param"`
+}
+
+type tweedieRegressionParamJSON struct {
+	TweedieVariancePower string `json:"tweedie_variance_power"`
+}
+
+type quantileLossParamJSON struct {
+	QuantileAlpha floatArrayParam `json:"quantile_alpha"`
+}
+
+// floatArrayParam is a list of numbers, XGBoost stores it as a string like "[0.1, 0.5, 0.9]" or "0.5".
+type floatArrayParam []float64
+
+// UnmarshalJSON decodes a json array of numbers or a string holding one or a single number into floatArrayParam.
+func (p *floatArrayParam) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(strings.TrimSpace(s))
+		if len(data) == 0 {
+			*p = nil
+			return nil
+		}
+	}
+	if len(data) > 0 && data[0] != '[' {
+		var v float64
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("cannot decode %s as numbers", string(data))
+		}
+		*p = floatArrayParam{v}
+		return nil
+	}
+	var values []float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("cannot decode %s as numbers", string(data))
+	}
+	*p = values
+	return nil
+}
+
+// saveModelTreeJSON is a tree of save_model json, nodes are stored in parallel arrays indexed by node id.
+type saveModelTreeJSON struct {
+	LeftChildren       []int      `json:"left_children"`
+	RightChildren      []int      `json:"right_children"`
+	SplitIndices       []int      `json:"split_indices"`
+	SplitConditions    []float64  `json:"split_conditions"`
+	SplitType          []int      `json:"split_type"`
+	DefaultLeft        []jsonBool `json:"default_left"`
+	LossChanges        []float64  `json:"loss_changes"`
+	SumHessian         []float64  `json:"sum_hessian"`
+	Categories         []int      `json:"categories"`
+	CategoriesNodes    []int      `json:"categories_nodes"`
+	CategoriesSegments []int      `json:"categories_segments"`
+	CategoriesSizes    []int      `json:"categories_sizes"`
+}
+
+// jsonBool is a boolean encoded either as json boolean or as 0 and 1 depending on XGBoost version.
+type jsonBool bool
+
+// UnmarshalJSON decodes json boolean or number into jsonBool.
+func (b *jsonBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0":
+		*b = false
+	default:
+		return fmt.Errorf("cannot decode %s as boolean", string(data))
+	}
+	return nil
+}
+
+// parseIntParam parses an integer model parameter which is stored as string, empty string returns defaultVal.
+func parseIntParam(name, value string, defaultVal int) (int, error) {
+	if len(value) == 0 {
+		return defaultVal, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s %s: %w", name, value, err)
+	}
+	return v, nil
+}
+
+// baseMargin converts the base score of a model into margin space according to its objective.
+func baseMargin(objective string, baseScore float64) (float64, error) {
+	margin := baseScore
+	switch objective {
+	case "binary:logistic", "binary:logitraw", "reg:logistic":
+		margin = math.Log(baseScore / (1 - baseScore))
+	case "count:poisson", "reg:gamma", "reg:tweedie":
+		margin = math.Log(baseScore)
+	}
+	if !isFinite(margin) {
+		return 0, fmt.Errorf("base_score %g is out of range for objective %s", baseScore, objective)
+	}
+	return margin, nil
+}
+
+// toXGBoostJSON converts a save_model tree into the nested dump_model representation.
+func (t *saveModelTreeJSON) toXGBoostJSON() (*xgboostJSON, error) {
+	numNodes := len(t.LeftChildren)
+	if numNodes == 0 {
+		return nil, fmt.Errorf("empty tree")
+	}
+	if len(t.RightChildren) != numNodes || len(t.SplitIndices) != numNodes ||
+		len(t.SplitConditions) != numNodes || len(t.DefaultLeft) != numNodes {
+		return nil, fmt.Errorf("inconsistent number of nodes")
+	}
+	hasStats := len(t.LossChanges) == numNodes && len(t.SumHessian) == numNodes
+	isCategoricalNode := len(t.SplitType) == numNodes
+
+	nodes := make([]*xgboostJSON, numNodes)
+	// every node but the root has a single parent, otherwise the nested nodes would not form a tree and could
+	// even reference themselves.
+	hasParent := make([]bool, numNodes)
+	for i := range nodes {
+		nodes[i] = &xgboostJSON{NodeID: i}
+		if hasStats {
+			nodes[i].Cover = t.SumHessian[i]
+		}
+	}
+	for i, node := range nodes {
+		left, right := t.LeftChildren[i], t.RightChildren[i]
+		if left == -1 {
+			node.LeafValue = t.SplitConditions[i]
+			continue
+		}
+		if left <= 0 || left >= numNodes || right <= 0 || right >= numNodes {
+			return nil, fmt.Errorf("node %d has children out of range", i)
+		}
+		for _, child := range []int{left, right} {
+			if child == i {
+				return nil, fmt.Errorf("node %d references itself", i)
+			}
+			if hasParent[child] {
+				return nil, fmt.Errorf("node %d references node %d, which already has a parent", i, child)
+			}
+			hasParent[child] = true
+		}
+		if t.SplitIndices[i] < 0 {
+			return nil, fmt.Errorf("node %d has invalid split index %d", i, t.SplitIndices[i])
+		}
+		node.SplitFeatureID = fmt.Sprintf("f%d", t.SplitIndices[i])
+		node.SplitFeatureThreshold = &t.SplitConditions[i]
+		node.YesID, node.NoID = left, right
+		if isCategoricalNode && t.SplitType[i] == categoricalSplit {
+			// categories in the split set go to the right child.
+			node.SplitType = categoricalSplit
+			node.YesID, node.NoID = right, left
+		}
+		node.MissingID = right
+		if t.DefaultLeft[i] {
+			node.MissingID = left
+		}
+		if hasStats {
+			node.Gain = t.LossChanges[i]
+		}
+		node.Children = []*xgboostJSON{nodes[node.YesID], nodes[node.NoID]}
+	}
+	for k, nid := range t.CategoriesNodes {
+		if k >= len(t.CategoriesSegments) || k >= len(t.CategoriesSizes) || nid < 0 || nid >= numNodes {
+			return nil, fmt.Errorf("wrong categories of node %d", nid)
+		}
+		start, size := t.CategoriesSegments[k], t.CategoriesSizes[k]
+		if start < 0 || size < 0 || start > len(t.Categories) || size > len(t.Categories)-start {
+			return nil, fmt.Errorf("wrong categories of node %d", nid)
+		}
+		nodes[nid].Categories = t.Categories[start : start+size]
+	}
+	return nodes[0], nil
+}
+
+// LoadXGBoostFromSaveModelJSON loads xgboost model from json file generated by save_model API. The number of
+// classes, number of parallel trees, base score and activation are read from the model. Split features are feature
+// indices, feature names recorded by models trained on named features only label them, and so does a feature map
+// passed as option, which replaces the recorded names. If the file contains an array of trees generated by dump_model API, it is loaded as a single class model with raw activation.
+func LoadXGBoostFromSaveModelJSON(modelPath string, opts ...LoadOption) (*inference.Ensemble, error) {
+	modelFile, err := os.Open(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	defer modelFile.Close()
+
+	return LoadXGBoostFromSaveModelReader(modelFile, opts...)
+}
+
+// LoadXGBoostFromSaveModelReader loads xgboost model from a reader of json content generated by save_model API,
+// see LoadXGBoostFromSaveModelJSON. The reader is not closed.
+func LoadXGBoostFromSaveModelReader(r io.Reader, opts ...LoadOption) (*inference.Ensemble, error) {
+	modelReader, err := decompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(modelReader)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return LoadXGBoostFromReader(bytes.NewReader(data), nil, 1, 0, &activation.Raw{}, opts...)
+	}
+
+	var model saveModelJSON
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, err
+	}
+	return loadSaveModel(&model, 0, 0, nil, opts...)
+}
+
+// numClasses returns the number of output groups of the model. A numClasses of 0 reads it from the model, otherwise
+// it must match the model.
+func (l *learnerJSON) numClasses(numClasses int) (int, error) {
+	modelNumClasses, err := parseIntParam("num_class", l.LearnerModelParam.NumClass, 0)
+	if err != nil {
+		return 0, err
+	}
+	numTargets, err := parseIntParam("num_target", l.LearnerModelParam.NumTarget, 1)
+	if err != nil {
+		return 0, err
+	}
+	if numTargets > 1 {
+		// multi-output models like multiple quantile regression have one output group per target.
+		if modelNumClasses > 1 {
+			return 0, fmt.Errorf("multi-output model with %d targets and %d classes is not supported", numTargets,
+				modelNumClasses)
+		}
+		modelNumClasses = numTargets
+	}
+	if modelNumClasses == 0 {
+		// binary classification and regression models have 0 class.
+		modelNumClasses = 1
+	}
+	if numClasses == 0 {
+		numClasses = modelNumClasses
+	} else if numClasses != modelNumClasses {
+		return 0, fmt.Errorf("%w: number of classes %d does not match num_class %d of the model",
+			inference.ErrClassMismatch, numClasses, modelNumClasses)
+	}
+	return numClasses, nil
+}
+
+// baseScore returns the base score of the model, 0.5 if it is not recorded.
+func (l *learnerJSON) baseScore() (float64, error) {
+	if len(l.LearnerModelParam.BaseScore) == 0 {
+		return 0.5, nil
+	}
+	baseScore, err := strconv.ParseFloat(l.LearnerModelParam.BaseScore, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse base_score %s: %w", l.LearnerModelParam.BaseScore, err)
+	}
+	if !isFinite(baseScore) {
+		return 0, fmt.Errorf("base_score is not finite: %f", baseScore)
+	}
+	return baseScore, nil
+}
+
+// tweedieVariancePower returns the variance power of a `reg:tweedie` objective, 0 for other objectives. It only
+// describes the training loss, predictions of tweedie models are the exponential of the margin whatever the power.
+func (o *objectiveJSON) tweedieVariancePower() (float64, error) {
+	value := o.TweedieRegressionParam.TweedieVariancePower
+	if o.Name != "reg:tweedie" || len(value) == 0 {
+		return 0, nil
+	}
+	power, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse tweedie_variance_power %s: %w", value, err)
+	}
+	if power < 1 || power >= 2 {
+		return 0, fmt.Errorf("tweedie_variance_power must be in range [1, 2), got %g", power)
+	}
+	return power, nil
+}
+
+// featureOptions returns the options setting the feature names and types recorded in the model, which are empty
+// unless the model is trained on named features. A numFeatures of 0 skips the check of the number of names.
+func (l *learnerJSON) featureOptions(numFeatures int) ([]LoadOption, error) {
+	names, types := l.FeatureNames, l.FeatureTypes
+	if len(names) == 0 {
+		if len(types) != 0 {
+			return nil, fmt.Errorf("model has %d feature types without feature names", len(types))
+		}
+		return nil, nil
+	}
+	if numFeatures > 0 && len(names) != numFeatures {
+		return nil, fmt.Errorf("model has %d feature names, expected num_feature %d", len(names), numFeatures)
+	}
+	opts := []LoadOption{withFeatureNames(names)}
+	if len(types) != 0 {
+		if len(types) != len(names) {
+			return nil, fmt.Errorf("model has %d feature types for %d feature names", len(types), len(names))
+		}
+		featureTypes := make(map[int]string, len(types))
+		for idx, t := range types {
+			featureTypes[idx] = t
+		}
+		opts = append(opts, withFeatureTypes(featureTypes))
+	}
+	return opts, nil
+}
+
+// loadSaveModel builds the ensemble of a save_model json. A numClasses of 0 reads the number of classes from the
+// model, otherwise it must match the model. A nil activation is picked from the objective.
+func loadSaveModel(model *saveModelJSON, numClasses int, maxDepth int, act activation.Activation,
+	opts ...LoadOption) (*inference.Ensemble, error) {
+	learner := &model.Learner
+	booster := &learner.GradientBooster
+	if booster.Name == "dart" {
+		// trees of DART boosters are scaled by their weight at prediction.
+		if booster.GBTree == nil {
+			return nil, fmt.Errorf("dart booster has no gbtree")
+		}
+		opts = append([]LoadOption{WithTreeWeights(booster.WeightDrop)}, opts...)
+		booster = booster.GBTree
+	}
+	if booster.Name == "gblinear" {
+		return loadLinearSaveModel(learner, numClasses, act)
+	}
+	if booster.Name != "gbtree" {
+		return nil, fmt.Errorf("unsupported booster %s", booster.Name)
+	}
+	gbTree := &booster.Model
+
+	numClasses, err := learner.numClasses(numClasses)
+	if err != nil {
+		return nil, err
+	}
+	numParallelTree, err := parseIntParam("num_parallel_tree", gbTree.GBTreeModelParam.NumParallelTree, 1)
+	if err != nil {
+		return nil, err
+	}
+	if numParallelTree <= 0 {
+		return nil, fmt.Errorf("num parallel tree cannot be 0 or smaller: %d", numParallelTree)
+	}
+	numFeatures, err := parseIntParam("num_feature", learner.LearnerModelParam.NumFeature, 0)
+	if err != nil {
+		return nil, err
+	}
+	if numFeatures < 0 || numFeatures > maxIndex {
+		return nil, fmt.Errorf("num_feature %d is out of range", numFeatures)
+	}
+	baseScore, err := learner.baseScore()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(gbTree.TreeInfo) != 0 {
+		if len(gbTree.TreeInfo) != len(gbTree.Trees) {
+			return nil, fmt.Errorf("tree_info has %d entries for %d trees", len(gbTree.TreeInfo), len(gbTree.Trees))
+		}
+		for i, group := range gbTree.TreeInfo {
+			// trees of a boosting round are ordered by output group.
+			if expected := (i / numParallelTree) % numClasses; group != expected {
+				return nil, fmt.Errorf("tree %d belongs to output group %d, expected %d", i, group, expected)
+			}
+		}
+	}
+	objective := learner.Objective.Name
+	alphas := learner.Objective.QuantileLossParam.QuantileAlpha
+	if len(alphas) != 0 && len(alphas) != numClasses {
+		return nil, fmt.Errorf("%d quantile alphas for %d outputs", len(alphas), numClasses)
+	}
+
+	variancePower, err := learner.Objective.tweedieVariancePower()
+	if err != nil {
+		return nil, err
+	}
+	featureOpts, err := learner.featureOptions(numFeatures)
+	if err != nil {
+		return nil, err
+	}
+
+	trees := make([]*xgboostJSON, len(gbTree.Trees))
+	for i, tree := range gbTree.Trees {
+		trees[i], err = tree.toXGBoostJSON()
+		if err != nil {
+			return nil, fmt.Errorf("error while reading %d tree: %w", i, err)
+		}
+	}
+
+	opts = append([]LoadOption{
+		withPositionalSplits(),
+		WithNumParallelTree(numParallelTree),
+		withObjective(objective),
+		withNumFeatures(numFeatures),
+		withQuantileAlphas(alphas),
+		withTweedieVariancePower(variancePower),
+	}, append(featureOpts, opts...)...)
+	if act == nil {
+		act = activation.FromObjective(objective)
+	}
+	margin, err := baseMargin(objective, baseScore)
+	if err != nil {
+		return nil, err
+	}
+	ensemble, err := loadXGBoost(trees, nil, numClasses, maxDepth, act, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ensemble.BaseScore = margin
+	return ensemble, nil
+}
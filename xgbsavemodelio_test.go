@@ -0,0 +1,466 @@
+package xgboost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/mat"
+	"github.com/Elvenson/xgboost-go/protobuf"
+)
+
+func TestLoadXGBoostFromSaveModelJSON(t *testing.T) {
+	tests := []struct {
+		modelPath    string
+		inputPath    string
+		expectedPath string
+		numClasses   int
+		objective    string
+		activation   protobuf.ActivateType
+	}{
+		{
+			"test/data/iris_xgboost_save_model.json", "test/data/iris_test.libsvm",
+			"test/data/iris_xgboost_true_prediction_proba.txt", 3, "multi:softprob", protobuf.ActivateType_SOFTMAX,
+		},
+		{
+			"test/data/breast_cancer_xgboost_save_model.json", "test/data/breast_cancer_test.libsvm",
+			"test/data/breast_cancer_xgboost_true_prediction.txt", 1, "binary:logistic",
+			protobuf.ActivateType_LOGISTIC,
+		},
+		{
+			"test/data/breast_cancer_xgboost_save_model_regression.json", "test/data/breast_cancer_test.libsvm",
+			"test/data/breast_cancer_xgboost_true_prediction_regression.txt", 1, "reg:linear",
+			protobuf.ActivateType_RAW,
+		},
+	}
+	for _, tc := range tests {
+		ensemble, err := LoadXGBoostFromSaveModelJSON(tc.modelPath)
+		assert.NilError(t, err)
+		assert.Equal(t, ensemble.NumClasses(), tc.numClasses)
+		assert.Equal(t, ensemble.Objective(), tc.objective)
+		assert.Equal(t, ensemble.Type(), tc.activation)
+
+		input, err := mat.ReadLibsvmFileToSparseMatrix(tc.inputPath)
+		assert.NilError(t, err)
+		predictions, err := ensemble.PredictProba(input)
+		assert.NilError(t, err)
+
+		expected, err := mat.ReadCSVFileToDenseMatrix(tc.expectedPath, "\t", 0.0)
+		assert.NilError(t, err)
+		err = mat.IsEqualMatrices(&predictions, &expected, 0.0001)
+		assert.NilError(t, err, tc.modelPath)
+	}
+}
+
+func TestLoadXGBoostFromSaveModelJSON_TreeArray(t *testing.T) {
+	ensemble, err := LoadXGBoostFromSaveModelJSON("test/data/breast_cancer_xgboost_dump.json")
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumClasses(), 1)
+	assert.Equal(t, ensemble.Type(), protobuf.ActivateType_RAW)
+	assert.Equal(t, ensemble.Objective(), "")
+}
+
+func TestLoadXGBoostFromSaveModelJSON_Categorical(t *testing.T) {
+	model := `{"learner": {
+	  "gradient_booster": {"name": "gbtree", "model": {
+	    "gbtree_model_param": {"num_parallel_tree": "1", "num_trees": "1"},
+	    "trees": [{
+	      "left_children": [1, -1, -1], "right_children": [2, -1, -1], "split_indices": [0, 0, 0],
+	      "split_conditions": [0, -0.5, 0.5], "split_type": [1, 0, 0], "default_left": [1, 0, 0],
+	      "categories": [1, 3], "categories_nodes": [0], "categories_segments": [0], "categories_sizes": [2]
+	    }]}},
+	  "learner_model_param": {"base_score": "0.0", "num_class": "0", "num_feature": "1"},
+	  "objective": {"name": "reg:squarederror"}
+	}}`
+	ensemble, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+	assert.NilError(t, err)
+
+	tests := []struct {
+		features mat.Vector
+		expected float64
+	}{
+		{mat.Vector{1}, 0.5},
+		{mat.Vector{3}, 0.5},
+		{mat.Vector{2}, -0.5},
+		{mat.Vector{math.NaN()}, -0.5},
+	}
+	for _, tc := range tests {
+		pred, err := ensemble.PredictRow(tc.features)
+		assert.NilError(t, err)
+		assert.Equal(t, pred[0], tc.expected, "features %v", tc.features)
+	}
+}
+
+func TestLoadXGBoostFromSaveModelJSON_RegressionObjectives(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_save_model_regression.json"
+	linear, err := LoadXGBoostFromSaveModelJSON(modelPath)
+	assert.NilError(t, err)
+	data, err := ioutil.ReadFile(modelPath)
+	assert.NilError(t, err)
+	input := breastCancerDenseInput(t, linear.NumFeatures(), 1)
+	baseScore := 0.6373626
+
+	tests := []struct {
+		objective  string
+		activation protobuf.ActivateType
+		transform  func(leafSum float64) float64
+	}{
+		{"reg:squarederror", protobuf.ActivateType_RAW, func(s float64) float64 { return baseScore + s }},
+		{"reg:logistic", protobuf.ActivateType_LOGISTIC, func(s float64) float64 {
+			return 1 / (1 + math.Exp(-(math.Log(baseScore/(1-baseScore)) + s)))
+		}},
+		{"count:poisson", protobuf.ActivateType_EXP, func(s float64) float64 { return baseScore * math.Exp(s) }},
+		{"reg:tweedie", protobuf.ActivateType_EXP, func(s float64) float64 { return baseScore * math.Exp(s) }},
+	}
+	for _, tc := range tests {
+		model := strings.Replace(string(data), `"reg:linear"`, `"`+tc.objective+`"`, 1)
+		ensemble, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+		assert.NilError(t, err)
+		assert.Equal(t, ensemble.Type(), tc.activation, tc.objective)
+		for _, row := range input.Vectors {
+			margin, err := linear.PredictMargin(*row)
+			assert.NilError(t, err)
+			pred, err := ensemble.PredictRow(*row)
+			assert.NilError(t, err)
+			expected := tc.transform(margin[0] - baseScore)
+			assert.Assert(t, math.Abs(pred[0]-expected) < 1e-9, "%s: %f != %f", tc.objective, pred[0], expected)
+		}
+	}
+}
+
+func TestLoadXGBoostFromSaveModelJSON_Tweedie(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_save_model_regression.json"
+	linear, err := LoadXGBoostFromSaveModelJSON(modelPath)
+	assert.NilError(t, err)
+	assert.Equal(t, linear.TweedieVariancePower(), 0.0)
+	data, err := ioutil.ReadFile(modelPath)
+	assert.NilError(t, err)
+	tweedie := func(power string) string {
+		return strings.Replace(string(data), `"name": "reg:linear"`, `"name": "reg:tweedie",
+			"tweedie_regression_param": {"tweedie_variance_power": "`+power+`"}`, 1)
+	}
+
+	ensemble, err := LoadXGBoostFromSaveModelReader(strings.NewReader(tweedie("1.5")))
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.Type(), protobuf.ActivateType_EXP)
+	assert.Equal(t, ensemble.TweedieVariancePower(), 1.5)
+	for _, row := range breastCancerDenseInput(t, linear.NumFeatures(), 1).Vectors {
+		margin, err := ensemble.PredictMargin(*row)
+		assert.NilError(t, err)
+		pred, err := ensemble.PredictRow(*row)
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(pred[0]-math.Exp(margin[0])) < 1e-12)
+	}
+
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(tweedie("2")))
+	assert.Error(t, err, "tweedie_variance_power must be in range [1, 2), got 2")
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(tweedie("x")))
+	assert.ErrorContains(t, err, "cannot parse tweedie_variance_power x")
+}
+
+func TestLoadXGBoostFromSaveModelJSON_InvalidParams(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/breast_cancer_xgboost_save_model.json")
+	assert.NilError(t, err)
+	tests := []struct {
+		old, new string
+		err      string
+	}{
+		{`"base_score": "5.000000E-01"`, `"base_score": "NaN"`, "base_score is not finite: NaN"},
+		{`"base_score": "5.000000E-01"`, `"base_score": "1.5"`,
+			"base_score 1.5 is out of range for objective binary:logistic"},
+		{`"num_parallel_tree": "1"`, `"num_parallel_tree": "0"`, "num parallel tree cannot be 0 or smaller: 0"},
+		{`"num_feature": "30"}, "objective"`, `"num_feature": "-1"}, "objective"`, "num_feature -1 is out of range"},
+	}
+	for _, tc := range tests {
+		model := strings.Replace(string(data), tc.old, tc.new, 1)
+		_, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+		assert.Error(t, err, tc.err)
+	}
+}
+
+func TestLoadXGBoostFromSaveModelJSON_NumParallelTree(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_save_model.json"
+	boosted, err := LoadXGBoostFromSaveModelJSON(modelPath)
+	assert.NilError(t, err)
+	assert.Equal(t, boosted.NumParallelTree(), 1)
+	data, err := ioutil.ReadFile(modelPath)
+	assert.NilError(t, err)
+
+	// the same 10 trees read as 5 rounds of 2 parallel trees, a random forest style booster.
+	model := strings.Replace(string(data), `"num_parallel_tree": "1"`, `"num_parallel_tree": "2"`, 1)
+	forest, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+	assert.NilError(t, err)
+	assert.Equal(t, forest.NumParallelTree(), 2)
+	assert.Equal(t, forest.NumTrees(), 10)
+
+	for _, row := range breastCancerDenseInput(t, boosted.NumFeatures(), 1).Vectors {
+		margin, err := boosted.PredictMargin(*row)
+		assert.NilError(t, err)
+		pred, err := forest.PredictRow(*row)
+		assert.NilError(t, err)
+		// base margin is logit(0.5) = 0, parallel trees are averaged instead of summed.
+		expected := 1 / (1 + math.Exp(-margin[0]/2))
+		assert.Assert(t, math.Abs(pred[0]-expected) < 1e-9, "%f != %f", pred[0], expected)
+	}
+}
+
+func TestLoadXGBoostFromSaveModelJSON_NumParallelTreeXGBoostReference(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_parallel_tree_save_model.json"
+	expectedPath := "test/data/iris_xgboost_parallel_tree_true_prediction_proba.txt"
+	skipWithoutReference(t, "iris_xgboost.py", modelPath, expectedPath)
+
+	// 3 rounds of 4 parallel trees for each of the 3 classes, saved by DMLC XGBoost.
+	forest, err := LoadXGBoostFromSaveModelJSON(modelPath)
+	assert.NilError(t, err)
+	assert.Equal(t, forest.NumParallelTree(), 4)
+	assert.Equal(t, forest.NumTrees(), 3*4*3)
+	expected, err := mat.ReadCSVFileToDenseMatrix(expectedPath, "\t", 0.0)
+	assert.NilError(t, err)
+
+	predictions, err := forest.PredictBatch(irisDenseInput(t))
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 1e-5))
+}
+
+func TestLoadXGBoostFromJSON_NumClassesFromSaveModel(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_save_model.json", "", 0, 0, &activation.Softmax{})
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.NumClasses(), 3)
+
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	predictions, err := ensemble.PredictProba(input)
+	assert.NilError(t, err)
+	expected, err := mat.ReadCSVFileToDenseMatrix("test/data/iris_xgboost_true_prediction_proba.txt", "\t", 0.0)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 0.0001))
+
+	_, err = LoadXGBoostFromJSON("test/data/iris_xgboost_save_model.json", "", 2, 0, &activation.Softmax{})
+	assert.ErrorContains(t, err, "number of classes 2 does not match num_class 3 of the model")
+
+	_, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 0, 0, &activation.Softmax{})
+	assert.ErrorContains(t, err, "number of classes is required for dump_model json")
+}
+
+func TestLoadXGBoostFromSaveModelJSON_Dart(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_save_model.json"
+	gbtree, err := LoadXGBoostFromSaveModelJSON(modelPath)
+	assert.NilError(t, err)
+	data, err := ioutil.ReadFile(modelPath)
+	assert.NilError(t, err)
+
+	// the same trees wrapped in a DART booster, which scales every tree by its weight.
+	weights := []float64{1, 0.5, 0.25, 1, 0.8, 0.6, 1, 0.9, 0.3, 0.7}
+	weightDrop, err := json.Marshal(weights)
+	assert.NilError(t, err)
+	model := strings.Replace(string(data), `"gradient_booster": {`,
+		`"gradient_booster": {"name": "dart", "weight_drop": `+string(weightDrop)+`, "gbtree": {`, 1)
+	model = strings.Replace(model, `"name": "gbtree"}`, `"name": "gbtree"}}`, 1)
+	dart, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+	assert.NilError(t, err)
+	assert.Equal(t, dart.NumTrees(), 10)
+
+	for _, row := range breastCancerDenseInput(t, gbtree.NumFeatures(), 1).Vectors {
+		leaves, err := gbtree.PredictLeafIndices(*row)
+		assert.NilError(t, err)
+		expected := gbtree.BaseScore
+		for i, leaf := range leaves {
+			value, err := gbtree.LeafValue(i, leaf)
+			assert.NilError(t, err)
+			expected += weights[i] * value
+		}
+		margin, err := dart.PredictMargin(*row)
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(margin[0]-expected) < 1e-12, "%f != %f", margin[0], expected)
+		sparse := make(mat.SparseVector)
+		for j, v := range *row {
+			if !math.IsNaN(v) {
+				sparse[j] = v
+			}
+		}
+		pred, err := dart.PredictSparse(sparse)
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(pred[0]-1/(1+math.Exp(-expected))) < 1e-12)
+	}
+
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(
+		`{"learner": {"gradient_booster": {"name": "dart"}}}`))
+	assert.Error(t, err, "dart booster has no gbtree")
+	model = strings.Replace(model, string(weightDrop), "[1, 0.5]", 1)
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(model))
+	assert.Error(t, err, "number of tree weights 2 does not match number of trees 10")
+}
+
+// quantileModelJSON is a save_model json of a reg:quantileerror model with 3 quantiles and 2 boosting rounds, the
+// trees of output k split on feature 0 at 1 with leaves -k and k.
+func quantileModelJSON(alphas string, treeInfo string) string {
+	tree := func(k int) string {
+		return fmt.Sprintf(`{"left_children": [1, -1, -1], "right_children": [2, -1, -1], "split_indices": [0, 0, 0],
+			"split_conditions": [1, %d, %d], "default_left": [1, 0, 0]}`, -k, k)
+	}
+	trees := []string{tree(0), tree(1), tree(2), tree(0), tree(1), tree(2)}
+	return `{"learner": {
+		"gradient_booster": {"name": "gbtree", "model": {"gbtree_model_param": {"num_parallel_tree": "1"},
+			"tree_info": ` + treeInfo + `, "trees": [` + strings.Join(trees, ",") + `]}},
+		"learner_model_param": {"base_score": "1E1", "num_class": "0", "num_feature": "2", "num_target": "3"},
+		"objective": {"name": "reg:quantileerror", "quantile_loss_param": {"quantile_alpha": ` + alphas + `}}}}`
+}
+
+func TestLoadXGBoostFromSaveModelJSON_Quantiles(t *testing.T) {
+	for _, alphas := range []string{`"[0.1, 0.5, 0.9]"`, `[0.1, 0.5, 0.9]`} {
+		ensemble, err := LoadXGBoostFromSaveModelReader(strings.NewReader(
+			quantileModelJSON(alphas, "[0, 1, 2, 0, 1, 2]")))
+		assert.NilError(t, err)
+		assert.Equal(t, ensemble.NumClasses(), 3)
+		assert.Equal(t, ensemble.Type(), protobuf.ActivateType_RAW)
+		assert.DeepEqual(t, ensemble.QuantileAlphas(), []float64{0.1, 0.5, 0.9})
+
+		predictions, err := ensemble.PredictBatch(mat.Matrix{Vectors: []*mat.Vector{{0, 0}, {2, 0}}})
+		assert.NilError(t, err)
+		expected := mat.Matrix{Vectors: []*mat.Vector{{10, 8, 6}, {10, 12, 14}}}
+		assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 1e-12))
+	}
+
+	single, err := LoadXGBoostFromSaveModelJSON("test/data/breast_cancer_xgboost_save_model_regression.json")
+	assert.NilError(t, err)
+	assert.Assert(t, single.QuantileAlphas() == nil)
+
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(quantileModelJSON(`"[0.1, 0.9]"`, "[0, 1, 2, 0, 1, 2]")))
+	assert.Error(t, err, "2 quantile alphas for 3 outputs")
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(quantileModelJSON(`"[0.1, 0.5, 0.9]"`,
+		"[0, 0, 1, 1, 2, 2]")))
+	assert.Error(t, err, "tree 1 belongs to output group 0, expected 1")
+	_, err = LoadXGBoostFromSaveModelReader(strings.NewReader(quantileModelJSON(`"oops"`, "[0, 1, 2, 0, 1, 2]")))
+	assert.ErrorContains(t, err, "cannot decode oops as numbers")
+}
+
+func TestLoadXGBoostFromJSON_SaveModelWithFeatureMap(t *testing.T) {
+	modelPath := "test/data/breast_cancer_xgboost_save_model.json"
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "test/data/breast_cancer_fmap.txt", 0, 0, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, ensemble.FeatureNames()[0], "mean_radius")
+	withoutMap, err := LoadXGBoostFromJSON(modelPath, "", 0, 0, nil)
+	assert.NilError(t, err)
+
+	// splits of save_model json stay positional, the feature map only names the features.
+	input := breastCancerDenseInput(t, ensemble.NumFeatures(), 1)
+	predictions, err := ensemble.PredictBatch(input)
+	assert.NilError(t, err)
+	expected, err := withoutMap.PredictBatch(input)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&predictions, &expected, 1e-12))
+	importance, err := ensemble.FeatureImportanceWeightByName()
+	assert.NilError(t, err)
+	assert.Assert(t, len(importance) > 0)
+	for name := range importance {
+		assert.Assert(t, !strings.HasPrefix(name, "f"), name)
+	}
+}
+
+func TestLoadXGBoostFromSaveModelJSON_FeatureNames(t *testing.T) {
+	// the tree splits on feature 1 at 30.
+	model := func(names, types string) string {
+		return `{"learner": {
+			"feature_names": ` + names + `, "feature_types": ` + types + `,
+			"gradient_booster": {"name": "gbtree", "model": {"gbtree_model_param": {"num_parallel_tree": "1"},
+				"trees": [{"left_children": [1, -1, -1], "right_children": [2, -1, -1], "split_indices": [1, 0, 0],
+					"split_conditions": [30, -1, 1], "default_left": [1, 0, 0]}]}},
+			"learner_model_param": {"base_score": "0", "num_class": "0", "num_feature": "2"},
+			"objective": {"name": "reg:squarederror"}}}`
+	}
+
+	ensemble, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model(`["age", "income"]`, `["float", "int"]`)))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ensemble.FeatureNames(), []string{"age", "income"})
+	types, err := ensemble.FeatureTypes()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, types, map[int]string{0: "float", 1: "int"})
+	importance, err := ensemble.FeatureImportanceWeightByName()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, importance, map[string]int{"income": 1})
+	pred, err := ensemble.PredictRow(mat.Vector{50, 40})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 1.0)
+
+	// feature types are optional.
+	ensemble, err = LoadXGBoostFromSaveModelReader(strings.NewReader(model(`["age", "income"]`, `[]`)))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ensemble.FeatureNames(), []string{"age", "income"})
+	// a feature map passed as option takes precedence, it only names the features split by index.
+	ensemble, err = LoadXGBoostFromSaveModelReader(strings.NewReader(model(`["age", "income"]`, `[]`)),
+		WithFeatureMap(map[string]int{"years": 0, "salary": 1}))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ensemble.FeatureNames(), []string{"years", "salary"})
+	pred, err = ensemble.PredictByName(map[string]float64{"salary": 40})
+	assert.NilError(t, err)
+	assert.Equal(t, pred[0], 1.0)
+	// models trained without feature names have no feature map.
+	ensemble, err = LoadXGBoostFromSaveModelJSON("test/data/iris_xgboost_save_model.json")
+	assert.NilError(t, err)
+	assert.Assert(t, ensemble.FeatureNames() == nil)
+
+	tests := []struct {
+		names string
+		types string
+		err   string
+	}{
+		{`["age"]`, `[]`, "model has 1 feature names, expected num_feature 2"},
+		{`["age", "income"]`, `["float"]`, "model has 1 feature types for 2 feature names"},
+		{`[]`, `["float", "int"]`, "model has 2 feature types without feature names"},
+		{`["age", "age"]`, `[]`, "duplicate feature name age in model"},
+	}
+	for _, tc := range tests {
+		_, err := LoadXGBoostFromSaveModelReader(strings.NewReader(model(tc.names, tc.types)))
+		assert.Error(t, err, tc.err)
+	}
+}
+
+func TestSaveModelTree_ToXGBoostJSONCycles(t *testing.T) {
+	tests := []struct {
+		left  []int
+		right []int
+		err   string
+	}{
+		{[]int{1, 1, -1, -1}, []int{2, 3, -1, -1}, "node 1 references itself"},
+		{[]int{1, -1, -1, -1}, []int{1, -1, -1, -1}, "node 0 references node 1, which already has a parent"},
+		{[]int{1, 2, -1, -1}, []int{2, 3, -1, -1}, "node 1 references node 2, which already has a parent"},
+	}
+	for _, tc := range tests {
+		tree := &saveModelTreeJSON{
+			LeftChildren: tc.left, RightChildren: tc.right, SplitIndices: []int{0, 0, 0, 0},
+			SplitConditions: []float64{1, 2, 3, 4}, DefaultLeft: []jsonBool{true, true, true, true},
+		}
+		_, err := tree.toXGBoostJSON()
+		assert.Error(t, err, tc.err)
+	}
+}
+
+func TestSaveModelTree_ToXGBoostJSONOutOfRange(t *testing.T) {
+	tree := func() *saveModelTreeJSON {
+		return &saveModelTreeJSON{
+			LeftChildren: []int{1, -1, -1}, RightChildren: []int{2, -1, -1}, SplitIndices: []int{0, 0, 0},
+			SplitConditions: []float64{1, 2, 3}, DefaultLeft: []jsonBool{true, true, true},
+			SplitType: []int{categoricalSplit, 0, 0}, CategoriesNodes: []int{0}, CategoriesSegments: []int{0},
+			CategoriesSizes: []int{1}, Categories: []int{1},
+		}
+	}
+	_, err := tree().toXGBoostJSON()
+	assert.NilError(t, err)
+
+	negative := tree()
+	negative.SplitIndices[0] = -1
+	_, err = negative.toXGBoostJSON()
+	assert.Error(t, err, "node 0 has invalid split index -1")
+
+	// the segment end overflows.
+	overflow := tree()
+	overflow.CategoriesSegments[0] = math.MaxInt64
+	_, err = overflow.toXGBoostJSON()
+	assert.Error(t, err, "wrong categories of node 0")
+}
@@ -0,0 +1,350 @@
+package xgboost
+
+import (
+	"fmt"
+
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// pathElement is an element of the unique feature path followed by TreeSHAP.
+type pathElement struct {
+	featureIndex int
+	zeroFraction float64
+	oneFraction  float64
+	pweight      float64
+}
+
+// extendPath grows the path with a new feature split.
+func extendPath(path []pathElement, depth int, zeroFraction, oneFraction float64, featureIndex int) {
+	path[depth] = pathElement{featureIndex: featureIndex, zeroFraction: zeroFraction, oneFraction: oneFraction}
+	if depth == 0 {
+		path[depth].pweight = 1
+	}
+	for i := depth - 1; i >= 0; i-- {
+		path[i+1].pweight += oneFraction * path[i].pweight * float64(i+1) / float64(depth+1)
+		path[i].pweight = zeroFraction * path[i].pweight * float64(depth-i) / float64(depth+1)
+	}
+}
+
+// unwindPath undoes a previous extension of the path at pathIndex.
+func unwindPath(path []pathElement, depth int, pathIndex int) {
+	oneFraction := path[pathIndex].oneFraction
+	zeroFraction := path[pathIndex].zeroFraction
+	nextOnePortion := path[depth].pweight
+	for i := depth - 1; i >= 0; i-- {
+		if oneFraction != 0 {
+			tmp := path[i].pweight
+			path[i].pweight = nextOnePortion * float64(depth+1) / (float64(i+1) * oneFraction)
+			nextOnePortion = tmp - path[i].pweight*zeroFraction*float64(depth-i)/float64(depth+1)
+		} else {
+			path[i].pweight = path[i].pweight * float64(depth+1) / (zeroFraction * float64(depth-i))
+		}
+	}
+	for i := pathIndex; i < depth; i++ {
+		path[i].featureIndex = path[i+1].featureIndex
+		path[i].zeroFraction = path[i+1].zeroFraction
+		path[i].oneFraction = path[i+1].oneFraction
+	}
+}
+
+// unwoundPathSum returns the total permutation weight of the path if pathIndex was unwound.
+func unwoundPathSum(path []pathElement, depth int, pathIndex int) float64 {
+	oneFraction := path[pathIndex].oneFraction
+	zeroFraction := path[pathIndex].zeroFraction
+	nextOnePortion := path[depth].pweight
+	total := 0.0
+	for i := depth - 1; i >= 0; i-- {
+		if oneFraction != 0 {
+			tmp := nextOnePortion * float64(depth+1) / (float64(i+1) * oneFraction)
+			total += tmp
+			nextOnePortion = path[i].pweight - tmp*zeroFraction*float64(depth-i)/float64(depth+1)
+		} else if zeroFraction != 0 {
+			total += path[i].pweight / zeroFraction / (float64(depth-i) / float64(depth+1))
+		}
+	}
+	return total
+}
+
+// shapCondition fixes a feature for SHAP interaction values, a positive condition keeps only the hot path of the
+// feature splits and a negative one keeps only the cold path. A zero condition computes plain SHAP values.
+type shapCondition struct {
+	condition int
+	feature   int
+}
+
+// meanValues returns the cover weighted mean of the leaves of the subtree rooted at every node, indexed by node id.
+func (t *xgbTree) meanValues() ([]float64, error) {
+	means := make([]float64, len(t.nodes))
+	var mean func(idx int) error
+	mean = func(idx int) error {
+		node := t.nodes[idx]
+		if node.Flags&isLeaf > 0 {
+			means[idx] = node.LeafValues
+			return nil
+		}
+		if node.Cover == 0 {
+			return fmt.Errorf("node %d has no cover, please dump the model with statistics", node.NodeID)
+		}
+		if err := mean(node.Yes); err != nil {
+			return err
+		}
+		if err := mean(node.No); err != nil {
+			return err
+		}
+		means[idx] = (means[node.Yes]*t.nodes[node.Yes].Cover + means[node.No]*t.nodes[node.No].Cover) / node.Cover
+		return nil
+	}
+	if err := mean(0); err != nil {
+		return nil, err
+	}
+	return means, nil
+}
+
+// shapTree holds what TreeSHAP needs of a tree besides the features: the mean values of the subtrees and a path
+// buffer deep enough for the tree. It is computed once per tree and reused across feature vectors, it must not be
+// used by several goroutines at once.
+type shapTree struct {
+	tree  *xgbTree
+	means []float64
+	path  []pathElement
+}
+
+// newShapTree precomputes the TreeSHAP state of a tree.
+func newShapTree(t *xgbTree) (*shapTree, error) {
+	means, err := t.meanValues()
+	if err != nil {
+		return nil, err
+	}
+	maxDepth := t.depth(0) + 2
+	return &shapTree{tree: t, means: means, path: make([]pathElement, maxDepth*(maxDepth+1)/2)}, nil
+}
+
+// contributions adds the SHAP values of the tree for a dense feature vector into phi, which has one entry per
+// feature followed by the bias term.
+func (s *shapTree) contributions(features mat.Vector, phi []float64, cond shapCondition) {
+	if cond.condition == 0 {
+		phi[len(phi)-1] += s.means[0]
+	}
+	s.tree.treeSHAP(features, phi, 0, 0, s.path, 1, 1, -1, cond, 1)
+}
+
+// contributions adds the SHAP values of this tree for a dense feature vector into phi, which has one entry per
+// feature followed by the bias term.
+func (t *xgbTree) contributions(features mat.Vector, phi []float64, cond shapCondition) error {
+	s, err := newShapTree(t)
+	if err != nil {
+		return err
+	}
+	s.contributions(features, phi, cond)
+	return nil
+}
+
+// treeSHAP recursively computes SHAP values of the subtree rooted at node idx, following the algorithm in
+// "Consistent Individualized Feature Attribution for Tree Ensembles" by Lundberg et al. as implemented by DMLC XGBoost.
+func (t *xgbTree) treeSHAP(features mat.Vector, phi []float64, idx int, depth int, parentPath []pathElement,
+	parentZeroFraction, parentOneFraction float64, parentFeature int, cond shapCondition, conditionFraction float64) {
+	if conditionFraction == 0 {
+		return
+	}
+	node := t.nodes[idx]
+	// each level works on its own copy of the path.
+	path := parentPath[depth+1:]
+	copy(path, parentPath[:depth+1])
+	if cond.condition == 0 || cond.feature != parentFeature {
+		extendPath(path, depth, parentZeroFraction, parentOneFraction, parentFeature)
+	}
+
+	if node.Flags&isLeaf > 0 {
+		for i := 1; i <= depth; i++ {
+			w := unwoundPathSum(path, depth, i)
+			el := path[i]
+			phi[el.featureIndex] += w * (el.oneFraction - el.zeroFraction) * node.LeafValues * conditionFraction
+		}
+		return
+	}
+
+	hot := node.next(features[node.Feature], true)
+	cold := node.Yes
+	if hot == node.Yes {
+		cold = node.No
+	}
+	hotZeroFraction := t.nodes[hot].Cover / node.Cover
+	coldZeroFraction := t.nodes[cold].Cover / node.Cover
+	incomingZeroFraction, incomingOneFraction := 1.0, 1.0
+
+	// a feature already on the path is undone so every feature appears once.
+	pathIndex := 0
+	for ; pathIndex <= depth; pathIndex++ {
+		if path[pathIndex].featureIndex == node.Feature {
+			break
+		}
+	}
+	if pathIndex != depth+1 {
+		incomingZeroFraction = path[pathIndex].zeroFraction
+		incomingOneFraction = path[pathIndex].oneFraction
+		unwindPath(path, depth, pathIndex)
+		depth--
+	}
+
+	hotConditionFraction, coldConditionFraction := conditionFraction, conditionFraction
+	if cond.condition > 0 && node.Feature == cond.feature {
+		coldConditionFraction = 0
+		depth--
+	} else if cond.condition < 0 && node.Feature == cond.feature {
+		hotConditionFraction *= hotZeroFraction
+		coldConditionFraction *= coldZeroFraction
+		depth--
+	}
+
+	t.treeSHAP(features, phi, hot, depth+1, path, hotZeroFraction*incomingZeroFraction, incomingOneFraction,
+		node.Feature, cond, hotConditionFraction)
+	t.treeSHAP(features, phi, cold, depth+1, path, coldZeroFraction*incomingZeroFraction, 0,
+		node.Feature, cond, coldConditionFraction)
+}
+
+// PredictContribs returns the SHAP values of a dense feature vector, NaN being a missing value. For every class it
+// holds one contribution per feature followed by the bias term, classes are laid out one after another. The values
+// of a class sum to its raw prediction. It needs node covers, which DMLC XGBoost dumps with `with_stats=True`.
+func (e *xgbEnsemble) PredictContribs(features mat.Vector) (mat.Vector, error) {
+	return e.predictContribs(features, func(treeIndex int, phi []float64) error {
+		return e.Trees[treeIndex].contributions(features, phi, shapCondition{})
+	})
+}
+
+// PredictContribsBatch returns the SHAP values of every row of a dense matrix, laid out like PredictContribs. The mean
+// values of the subtrees are computed once for all rows instead of once per row.
+func (e *xgbEnsemble) PredictContribsBatch(features mat.Matrix) (mat.Matrix, error) {
+	trees := make([]*shapTree, len(e.Trees))
+	for i, tree := range e.Trees {
+		s, err := newShapTree(tree)
+		if err != nil {
+			return mat.Matrix{}, fmt.Errorf("error while computing contributions of %d tree: %w", i, err)
+		}
+		trees[i] = s
+	}
+	contribs := mat.Matrix{Vectors: make([]*mat.Vector, len(features.Vectors))}
+	for r, row := range features.Vectors {
+		phi, err := e.predictContribs(*row, func(treeIndex int, phi []float64) error {
+			trees[treeIndex].contributions(*row, phi, shapCondition{})
+			return nil
+		})
+		if err != nil {
+			return mat.Matrix{}, fmt.Errorf("row %d: %w", r, err)
+		}
+		contribs.Vectors[r] = &phi
+	}
+	return contribs, nil
+}
+
+// PredictInteractions returns the SHAP interaction values of a dense feature vector as DMLC XGBoost does with
+// `pred_interactions=True`. Every class has one row per feature followed by the bias row, each row holding one value
+// per feature followed by the bias term, classes are laid out one after another. Off diagonal values halve the
+// difference between the SHAP values of a feature when another one is fixed on its hot and on its cold path, the
+// diagonal holds what remains of the SHAP value of the feature and the bias row only holds the bias term.
+func (e *xgbEnsemble) PredictInteractions(features mat.Vector) (mat.Matrix, error) {
+	diag, err := e.PredictContribs(features)
+	if err != nil {
+		return mat.Matrix{}, err
+	}
+	stride := e.numFeat + 1
+	interactions := mat.Matrix{Vectors: make([]*mat.Vector, e.numClasses*stride)}
+	for i := range interactions.Vectors {
+		row := make(mat.Vector, stride)
+		interactions.Vectors[i] = &row
+	}
+	conditioned := func(condition, feature int) (mat.Vector, error) {
+		return e.predictContribs(features, func(treeIndex int, phi []float64) error {
+			return e.Trees[treeIndex].contributions(features, phi, shapCondition{condition: condition,
+				feature: feature})
+		})
+	}
+	for i := 0; i < stride; i++ {
+		on, err := conditioned(1, i)
+		if err != nil {
+			return mat.Matrix{}, err
+		}
+		off, err := conditioned(-1, i)
+		if err != nil {
+			return mat.Matrix{}, err
+		}
+		for c := 0; c < e.numClasses; c++ {
+			row := *interactions.Vectors[c*stride+i]
+			offset := c * stride
+			row[i] = diag[offset+i]
+			for k := 0; k < stride; k++ {
+				if k == i {
+					continue
+				}
+				row[k] = (on[offset+k] - off[offset+k]) / 2
+				row[i] -= row[k]
+			}
+		}
+	}
+	return interactions, nil
+}
+
+// predictContribs accumulates per class contributions computed by contribs for every tree index.
+func (e *xgbEnsemble) predictContribs(features mat.Vector, contribs func(treeIndex int, phi []float64) error) (
+	mat.Vector, error) {
+	if len(features) < e.numFeat {
+		return mat.Vector{}, fmt.Errorf("expected at least %d features, got %d", e.numFeat, len(features))
+	}
+	stride := e.numFeat + 1
+	phi := make(mat.Vector, e.numClasses*stride)
+	var treePhi mat.Vector
+	if e.treeWeights != nil {
+		// contributions of a weighted tree are computed apart then scaled.
+		treePhi = make(mat.Vector, stride)
+	}
+	for i := range e.Trees {
+		class := e.treeClass(i)
+		classPhi := phi[class*stride : (class+1)*stride]
+		if treePhi == nil {
+			if err := contribs(i, classPhi); err != nil {
+				return mat.Vector{}, fmt.Errorf("error while computing contributions of %d tree: %w", i, err)
+			}
+			continue
+		}
+		for j := range treePhi {
+			treePhi[j] = 0
+		}
+		if err := contribs(i, treePhi); err != nil {
+			return mat.Vector{}, fmt.Errorf("error while computing contributions of %d tree: %w", i, err)
+		}
+		for j, v := range treePhi {
+			classPhi[j] += e.treeWeight(i) * v
+		}
+	}
+	if e.numParallelTree > 1 {
+		for i := range phi {
+			phi[i] /= float64(e.numParallelTree)
+		}
+	}
+	return phi, nil
+}
+
+// approxContributions adds the Saabas contributions of this tree for a dense feature vector into phi: every split
+// on the decision path attributes the change of the expected value to its feature.
+func (t *xgbTree) approxContributions(features mat.Vector, phi []float64) error {
+	means, err := t.meanValues()
+	if err != nil {
+		return err
+	}
+	phi[len(phi)-1] += means[0]
+	idx := 0
+	for t.nodes[idx].Flags&isLeaf == 0 {
+		node := t.nodes[idx]
+		next := node.next(features[node.Feature], true)
+		phi[node.Feature] += means[next] - means[idx]
+		idx = next
+	}
+	return nil
+}
+
+// PredictContribsApprox is like PredictContribs but uses the Saabas method, which is cheaper than TreeSHAP. Unlike
+// SHAP values the result depends on the order of the splits in the trees.
+func (e *xgbEnsemble) PredictContribsApprox(features mat.Vector) (mat.Vector, error) {
+	return e.predictContribs(features, func(treeIndex int, phi []float64) error {
+		return e.Trees[treeIndex].approxContributions(features, phi)
+	})
+}
@@ -0,0 +1,443 @@
+package xgboost
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/Elvenson/xgboost-go/activation"
+	"github.com/Elvenson/xgboost-go/inference"
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// setCovers sets node covers of every tree from the number of rows reaching each leaf, plus one so that no node
+// is empty. Covers of split nodes are the sum of their children as in DMLC XGBoost.
+func setCovers(tb testing.TB, ensemble *inference.Ensemble, rows mat.Matrix) {
+	xgb := ensemble.EnsembleBase.(*xgbEnsemble)
+	for _, tree := range xgb.Trees {
+		counts := make(map[int]float64)
+		for _, row := range rows.Vectors {
+			leaf, err := tree.leafDense(*row)
+			assert.NilError(tb, err)
+			counts[leaf.NodeID]++
+		}
+		var cover func(idx int) float64
+		cover = func(idx int) float64 {
+			node := tree.nodes[idx]
+			if node.Flags&isLeaf > 0 {
+				node.Cover = counts[idx] + 1
+			} else {
+				node.Cover = cover(node.Yes) + cover(node.No)
+			}
+			return node.Cover
+		}
+		cover(0)
+	}
+}
+
+// conditionalExpectation returns the expected output of the subtree rooted at node idx when only features in
+// known are set, unknown features follow both children weighted by cover.
+func conditionalExpectation(tree *xgbTree, idx int, features mat.Vector, known map[int]bool) float64 {
+	node := tree.nodes[idx]
+	if node.Flags&isLeaf > 0 {
+		return node.LeafValues
+	}
+	if known[node.Feature] {
+		return conditionalExpectation(tree, node.next(features[node.Feature], true), features, known)
+	}
+	yes := conditionalExpectation(tree, node.Yes, features, known)
+	no := conditionalExpectation(tree, node.No, features, known)
+	return (yes*tree.nodes[node.Yes].Cover + no*tree.nodes[node.No].Cover) / node.Cover
+}
+
+// bruteForceContribs computes exact Shapley values of every tree by enumerating all feature subsets.
+func bruteForceContribs(xgb *xgbEnsemble, features mat.Vector) mat.Vector {
+	m := xgb.numFeat
+	stride := m + 1
+	contribs := make(mat.Vector, xgb.numClasses*stride)
+	for t, tree := range xgb.Trees {
+		class := xgb.treeClass(t)
+		value := func(subset int) float64 {
+			known := make(map[int]bool)
+			for f := 0; f < m; f++ {
+				if subset&(1<<uint(f)) > 0 {
+					known[f] = true
+				}
+			}
+			return conditionalExpectation(tree, 0, features, known)
+		}
+		contribs[class*stride+m] += value(0)
+		for f := 0; f < m; f++ {
+			for subset := 0; subset < 1<<uint(m); subset++ {
+				if subset&(1<<uint(f)) > 0 {
+					continue
+				}
+				size := 0
+				for g := 0; g < m; g++ {
+					if subset&(1<<uint(g)) > 0 {
+						size++
+					}
+				}
+				weight := factorial(size) * factorial(m-size-1) / factorial(m)
+				contribs[class*stride+f] += weight * (value(subset|1<<uint(f)) - value(subset))
+			}
+		}
+	}
+	return contribs
+}
+
+// bruteForceInteractions computes exact Shapley interaction values of every tree by enumerating all feature subsets,
+// main effects on the diagonal are what remains of the Shapley values.
+func bruteForceInteractions(xgb *xgbEnsemble, features mat.Vector) mat.Matrix {
+	m := xgb.numFeat
+	stride := m + 1
+	contribs := bruteForceContribs(xgb, features)
+	interactions := mat.Matrix{Vectors: make([]*mat.Vector, xgb.numClasses*stride)}
+	for i := range interactions.Vectors {
+		row := make(mat.Vector, stride)
+		interactions.Vectors[i] = &row
+	}
+	for t, tree := range xgb.Trees {
+		class := xgb.treeClass(t)
+		value := func(subset int) float64 {
+			known := make(map[int]bool)
+			for f := 0; f < m; f++ {
+				if subset&(1<<uint(f)) > 0 {
+					known[f] = true
+				}
+			}
+			return conditionalExpectation(tree, 0, features, known)
+		}
+		for i := 0; i < m; i++ {
+			for j := 0; j < m; j++ {
+				if i == j {
+					continue
+				}
+				pair := 1<<uint(i) | 1<<uint(j)
+				for subset := 0; subset < 1<<uint(m); subset++ {
+					if subset&pair > 0 {
+						continue
+					}
+					size := 0
+					for g := 0; g < m; g++ {
+						if subset&(1<<uint(g)) > 0 {
+							size++
+						}
+					}
+					weight := factorial(size) * factorial(m-size-2) / (2 * factorial(m-1))
+					delta := value(subset|pair) - value(subset|1<<uint(i)) - value(subset|1<<uint(j)) + value(subset)
+					(*interactions.Vectors[class*stride+i])[j] += weight * delta
+				}
+			}
+		}
+	}
+	for c := 0; c < xgb.numClasses; c++ {
+		for i := 0; i < stride; i++ {
+			row := *interactions.Vectors[c*stride+i]
+			row[i] = contribs[c*stride+i]
+			for j := 0; j < stride; j++ {
+				if j != i {
+					row[i] -= row[j]
+				}
+			}
+		}
+	}
+	return interactions
+}
+
+func factorial(n int) float64 {
+	r := 1.0
+	for i := 2; i <= n; i++ {
+		r *= float64(i)
+	}
+	return r
+}
+
+func irisDenseInput(t *testing.T) mat.Matrix {
+	input, err := mat.ReadLibsvmFileToSparseMatrix("test/data/iris_test.libsvm")
+	assert.NilError(t, err)
+	dense := mat.Matrix{Vectors: make([]*mat.Vector, len(input.Vectors))}
+	for i, row := range input.Vectors {
+		dense.Vectors[i] = &mat.Vector{row[0], row[1], row[2], row[3]}
+	}
+	return dense
+}
+
+func TestEnsemble_PredictContribs(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	ensemble.BaseScore = 0.5
+	input := irisDenseInput(t)
+	setCovers(t, ensemble, input)
+	input.Vectors = append(input.Vectors, &mat.Vector{5.0, math.NaN(), 4.5, math.NaN()})
+
+	xgb := ensemble.EnsembleBase.(*xgbEnsemble)
+	for _, row := range input.Vectors {
+		contribs, err := ensemble.PredictContribs(*row)
+		assert.NilError(t, err)
+		assert.Equal(t, len(contribs), 3*5)
+
+		expected := bruteForceContribs(xgb, *row)
+		for c := 0; c < 3; c++ {
+			expected[c*5+4] += ensemble.BaseScore
+		}
+		assert.NilError(t, mat.IsEqualVectors(&contribs, &expected, 1e-9))
+
+		margin, err := ensemble.PredictMargin(*row)
+		assert.NilError(t, err)
+		for c := 0; c < 3; c++ {
+			sum := 0.0
+			for _, v := range contribs[c*5 : (c+1)*5] {
+				sum += v
+			}
+			assert.Assert(t, math.Abs(sum-margin[c]) < 1e-9, "class %d: %f != %f", c, sum, margin[c])
+		}
+	}
+}
+
+// skipWithoutReference skips the test unless the reference output of DMLC XGBoost recorded by script in test/scripts
+// is in test/data.
+func skipWithoutReference(t *testing.T, script string, paths ...string) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			t.Skipf("%s is missing, run test/scripts/%s with DMLC XGBoost to record it", path, script)
+		}
+	}
+}
+
+func TestEnsemble_PredictContribsXGBoostReference(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump_stats.json"
+	expectedPath := "test/data/iris_xgboost_true_contribs.txt"
+	skipWithoutReference(t, "iris_xgboost.py", modelPath, expectedPath)
+
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	ensemble.BaseScore = 0.5
+	expected, err := mat.ReadCSVFileToDenseMatrix(expectedPath, "\t", 0.0)
+	assert.NilError(t, err)
+
+	contribs, err := ensemble.PredictContribsBatch(irisDenseInput(t))
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualMatrices(&contribs, &expected, 1e-4))
+}
+
+func TestEnsemble_PredictContribsParallelTrees(t *testing.T) {
+	model := "[" + strings.Repeat(statsTreeJSON+",", 3) + statsTreeJSON + "]"
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{}, WithNumParallelTree(2))
+	assert.NilError(t, err)
+
+	features := mat.Vector{1.0, 0.0, 3.0}
+	contribs, err := ensemble.PredictContribs(features)
+	assert.NilError(t, err)
+	// 2 boosting rounds of the same tree, parallel trees are averaged.
+	expected := bruteForceContribs(ensemble.EnsembleBase.(*xgbEnsemble), features)
+	for i := range expected {
+		expected[i] /= 2
+	}
+	assert.NilError(t, mat.IsEqualVectors(&contribs, &expected, 1e-12))
+
+	// the path reaches leaf 5 (0.3), mean of the tree is 0.21.
+	assert.Assert(t, math.Abs(contribs[3]-2*0.21) < 1e-12)
+	margin, err := ensemble.PredictMargin(features)
+	assert.NilError(t, err)
+	assert.Assert(t, math.Abs(contribs[0]+contribs[1]+contribs[2]+contribs[3]-margin[0]) < 1e-12)
+}
+
+func TestEnsemble_PredictContribsBatch(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	ensemble.BaseScore = 0.5
+	input := irisDenseInput(t)
+	setCovers(t, ensemble, input)
+	input.Vectors = append(input.Vectors, &mat.Vector{5.0, -1, 4.5, -1})
+	ensemble.SetMissingValue(-1)
+
+	contribs, err := ensemble.PredictContribsBatch(input)
+	assert.NilError(t, err)
+	assert.Equal(t, len(contribs.Vectors), len(input.Vectors))
+	for i, row := range input.Vectors {
+		expected, err := ensemble.PredictContribs(*row)
+		assert.NilError(t, err)
+		assert.NilError(t, mat.IsEqualVectors(contribs.Vectors[i], &expected, 1e-12))
+	}
+	// rows are not modified by the missing value translation.
+	assert.Equal(t, (*input.Vectors[len(input.Vectors)-1])[1], -1.0)
+
+	input.Vectors = append(input.Vectors, &mat.Vector{5.0})
+	_, err = ensemble.PredictContribsBatch(input)
+	assert.ErrorContains(t, err, fmt.Sprintf("row %d: expected at least 4 features, got 1", len(input.Vectors)-1))
+	ensemble, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	_, err = ensemble.PredictContribsBatch(input)
+	assert.ErrorContains(t, err, "has no cover")
+}
+
+func TestEnsemble_PredictInteractionsXGBoostReference(t *testing.T) {
+	modelPath := "test/data/iris_xgboost_dump_stats.json"
+	expectedPath := "test/data/iris_xgboost_true_interactions.txt"
+	skipWithoutReference(t, "iris_xgboost.py", modelPath, expectedPath)
+
+	ensemble, err := LoadXGBoostFromJSON(modelPath, "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	ensemble.BaseScore = 0.5
+	expected, err := mat.ReadCSVFileToDenseMatrix(expectedPath, "\t", 0.0)
+	assert.NilError(t, err)
+
+	input := irisDenseInput(t)
+	// every row has a block of 5 rows for each of the 3 classes.
+	rowsPerInput := 3 * 5
+	assert.Equal(t, len(expected.Vectors), len(input.Vectors)*rowsPerInput)
+	for i, row := range input.Vectors {
+		interactions, err := ensemble.PredictInteractions(*row)
+		assert.NilError(t, err)
+		block := mat.Matrix{Vectors: expected.Vectors[i*rowsPerInput : (i+1)*rowsPerInput]}
+		assert.NilError(t, mat.IsEqualMatrices(&interactions, &block, 1e-4), "row %d", i)
+	}
+}
+
+// contribsBenchmarkEnsemble loads the breast cancer model with covers set from its test rows, which it returns.
+func contribsBenchmarkEnsemble(b *testing.B) (*inference.Ensemble, mat.Matrix) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/breast_cancer_xgboost_dump.json", "", 1, 4, &activation.Logistic{})
+	assert.NilError(b, err)
+	input := breastCancerDenseInput(b, ensemble.NumFeatures(), 1)
+	setCovers(b, ensemble, input)
+	return ensemble, input
+}
+
+func BenchmarkEnsemble_PredictContribsBatch(b *testing.B) {
+	ensemble, input := contribsBenchmarkEnsemble(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ensemble.PredictContribsBatch(input)
+		assert.NilError(b, err)
+	}
+}
+
+// BenchmarkEnsemble_PredictContribsRows is the baseline of BenchmarkEnsemble_PredictContribsBatch, it computes the
+// expected values of the trees again for every row.
+func BenchmarkEnsemble_PredictContribsRows(b *testing.B) {
+	ensemble, input := contribsBenchmarkEnsemble(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, row := range input.Vectors {
+			_, err := ensemble.PredictContribs(*row)
+			assert.NilError(b, err)
+		}
+	}
+}
+
+func TestEnsemble_PredictContribsWithoutCover(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	_, err = ensemble.PredictContribs(mat.Vector{6.0, 2.2, 4.0, 1.0})
+	assert.ErrorContains(t, err, "has no cover")
+}
+
+func TestEnsemble_PredictContribsApprox(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte("["+statsTreeJSON+"]"), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+
+	// the path goes root (mean 0.21) -> node 2 (mean 0.325) -> leaf 5 (0.3).
+	contribs, err := ensemble.PredictContribsApprox(mat.Vector{1.0, 0.0, 3.0})
+	assert.NilError(t, err)
+	expected := mat.Vector{0, -0.025, 0.115, 0.21}
+	assert.NilError(t, mat.IsEqualVectors(&contribs, &expected, 1e-12))
+
+	ensemble, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	_, err = ensemble.PredictContribsApprox(mat.Vector{6.0, 2.2, 4.0, 1.0})
+	assert.ErrorContains(t, err, "has no cover")
+
+	input := irisDenseInput(t)
+	setCovers(t, ensemble, input)
+	for _, row := range input.Vectors {
+		contribs, err := ensemble.PredictContribsApprox(*row)
+		assert.NilError(t, err)
+		margin, err := ensemble.PredictMargin(*row)
+		assert.NilError(t, err)
+		for c := 0; c < 3; c++ {
+			sum := 0.0
+			for _, v := range contribs[c*5 : (c+1)*5] {
+				sum += v
+			}
+			assert.Assert(t, math.Abs(sum-margin[c]) < 1e-9, "class %d: %f != %f", c, sum, margin[c])
+		}
+	}
+}
+
+func TestEnsemble_PredictContribsTreeWeights(t *testing.T) {
+	model := "[" + statsTreeJSON + "," + statsTreeJSON + "]"
+	ensemble, err := LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{},
+		WithTreeWeights([]float64{1, 0.5}))
+	assert.NilError(t, err)
+
+	features := mat.Vector{1.0, 0.0, 3.0}
+	margin, err := ensemble.PredictMargin(features)
+	assert.NilError(t, err)
+	// both trees reach leaf 5 (0.3).
+	assert.Assert(t, math.Abs(margin[0]-1.5*0.3) < 1e-12)
+
+	single, err := LoadXGBoostFromJSONBytes([]byte("["+statsTreeJSON+"]"), "", 1, 0, &activation.Raw{})
+	assert.NilError(t, err)
+	expected := bruteForceContribs(single.EnsembleBase.(*xgbEnsemble), features)
+	for i := range expected {
+		expected[i] *= 1.5
+	}
+	contribs, err := ensemble.PredictContribs(features)
+	assert.NilError(t, err)
+	assert.NilError(t, mat.IsEqualVectors(&contribs, &expected, 1e-12))
+
+	_, err = LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{}, WithTreeWeights([]float64{1}))
+	assert.Error(t, err, "number of tree weights 1 does not match number of trees 2")
+	_, err = LoadXGBoostFromJSONBytes([]byte(model), "", 1, 0, &activation.Raw{},
+		WithTreeWeights([]float64{1, math.Inf(1)}))
+	assert.ErrorContains(t, err, "weight of 1 tree is not finite")
+}
+
+func TestEnsemble_PredictInteractions(t *testing.T) {
+	ensemble, err := LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	ensemble.BaseScore = 0.5
+	input := irisDenseInput(t)
+	setCovers(t, ensemble, input)
+	input.Vectors = append(input.Vectors, &mat.Vector{5.0, math.NaN(), 4.5, math.NaN()})
+
+	xgb := ensemble.EnsembleBase.(*xgbEnsemble)
+	for _, row := range input.Vectors {
+		interactions, err := ensemble.PredictInteractions(*row)
+		assert.NilError(t, err)
+		assert.Equal(t, len(interactions.Vectors), 3*5)
+
+		expected := bruteForceInteractions(xgb, *row)
+		for c := 0; c < 3; c++ {
+			(*expected.Vectors[c*5+4])[4] += ensemble.BaseScore
+		}
+		assert.NilError(t, mat.IsEqualMatrices(&interactions, &expected, 1e-9))
+
+		// rows sum to the SHAP values and interactions are symmetric.
+		contribs, err := ensemble.PredictContribs(*row)
+		assert.NilError(t, err)
+		for i, r := range interactions.Vectors {
+			sum := 0.0
+			for j, v := range *r {
+				sum += v
+				other := (*interactions.Vectors[i/5*5+j])[i%5]
+				assert.Assert(t, math.Abs(v-other) < 1e-9, "row %d column %d: %f != %f", i, j, v, other)
+			}
+			assert.Assert(t, math.Abs(sum-contribs[i]) < 1e-9, "row %d: %f != %f", i, sum, contribs[i])
+		}
+	}
+
+	ensemble, err = LoadXGBoostFromJSON("test/data/iris_xgboost_dump.json", "", 3, 4, &activation.Softmax{})
+	assert.NilError(t, err)
+	_, err = ensemble.PredictInteractions(mat.Vector{6.0, 2.2, 4.0, 1.0})
+	assert.ErrorContains(t, err, "please dump the model with statistics")
+}
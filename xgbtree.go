@@ -2,13 +2,19 @@ package xgboost
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"unsafe"
 
 	"github.com/Elvenson/xgboost-go/mat"
 )
 
 // xgbtree constant values.
 const (
-	isLeaf = 1
+	isLeaf        = 1
+	isCategorical = 2
+	// isEmpty marks a node id without node in flat tree.
+	isEmpty = 4
 )
 
 type xgbNode struct {
@@ -20,30 +26,318 @@ type xgbNode struct {
 	Feature    int
 	Flags      uint8
 	LeafValues float64
+	Gain       float64
+	Cover      float64
+	Categories map[int]struct{}
 }
 
 type xgbTree struct {
 	nodes []*xgbNode
 }
 
+// next returns index of the child node to visit for a feature value, ok is false if the feature value is absent.
+func (n *xgbNode) next(v float64, ok bool) int {
+	if !ok || math.IsNaN(v) {
+		// missing value will be represented as NaN value.
+		return n.Missing
+	}
+	if n.Flags&isCategorical > 0 {
+		// categories in the split set go to the yes branch.
+		if _, ok := n.Categories[int(v)]; ok {
+			return n.Yes
+		}
+		return n.No
+	}
+	// values of int features are compared as floats too, as DMLC XGBoost does.
+	if v >= n.Threshold {
+		return n.No
+	}
+	return n.Yes
+}
+
+// sortedCategories returns the categories of a categorical split in ascending order.
+func (n *xgbNode) sortedCategories() []int {
+	categories := make([]int, 0, len(n.Categories))
+	for c := range n.Categories {
+		categories = append(categories, c)
+	}
+	sort.Ints(categories)
+	return categories
+}
+
 func (t *xgbTree) predict(features mat.SparseVector) (float64, error) {
+	node, err := t.leaf(features)
+	if err != nil {
+		return 0, err
+	}
+	return node.LeafValues, nil
+}
+
+func (t *xgbTree) predictDense(features mat.Vector) (float64, error) {
+	node, err := t.leafDense(features)
+	if err != nil {
+		return 0, err
+	}
+	return node.LeafValues, nil
+}
+
+// clone returns a deep copy of the tree.
+func (t *xgbTree) clone() *xgbTree {
+	c := &xgbTree{nodes: make([]*xgbNode, len(t.nodes))}
+	for i, node := range t.nodes {
+		if node == nil {
+			continue
+		}
+		n := *node
+		if node.Categories != nil {
+			n.Categories = make(map[int]struct{}, len(node.Categories))
+			for category := range node.Categories {
+				n.Categories[category] = struct{}{}
+			}
+		}
+		c.nodes[i] = &n
+	}
+	return c
+}
+
+// Approximate sizes used by memory estimates, a map entry also accounts for the buckets overhead.
+const (
+	pointerBytes  = int(unsafe.Sizeof(uintptr(0)))
+	mapBytes      = 48
+	mapEntryBytes = 24
+)
+
+// approxMemoryBytes estimates the memory held by the nodes of the tree.
+func (t *xgbTree) approxMemoryBytes() int {
+	size := int(unsafe.Sizeof(*t)) + len(t.nodes)*pointerBytes
+	for _, node := range t.nodes {
+		if node == nil {
+			continue
+		}
+		size += int(unsafe.Sizeof(*node))
+		if node.Categories != nil {
+			size += mapBytes + len(node.Categories)*mapEntryBytes
+		}
+	}
+	return size
+}
+
+// checkCovers returns an error if a split node of the tree has no cover.
+func (t *xgbTree) checkCovers() error {
+	for _, node := range t.nodes {
+		if node != nil && node.Flags&isLeaf == 0 && node.Cover == 0 {
+			return fmt.Errorf("node %d has no cover, please dump the model with statistics", node.NodeID)
+		}
+	}
+	return nil
+}
+
+// prune collapses split nodes of the subtree rooted at node idx whose gain is below minGain and whose children are
+// leaves, bottom up like DMLC XGBoost pruner. It returns the number of removed nodes.
+func (t *xgbTree) prune(idx int, minGain float64) int {
+	node := t.nodes[idx]
+	if node.Flags&isLeaf > 0 {
+		return 0
+	}
+	removed := t.prune(node.Yes, minGain) + t.prune(node.No, minGain)
+	yes, no := t.nodes[node.Yes], t.nodes[node.No]
+	if yes.Flags&isLeaf == 0 || no.Flags&isLeaf == 0 || node.Gain >= minGain {
+		return removed
+	}
+	t.nodes[idx] = &xgbNode{
+		NodeID:     node.NodeID,
+		Flags:      isLeaf,
+		LeafValues: (yes.LeafValues*yes.Cover + no.LeafValues*no.Cover) / node.Cover,
+		Cover:      node.Cover,
+	}
+	t.nodes[node.Yes], t.nodes[node.No] = nil, nil
+	return removed + 2
+}
+
+// depth returns the depth of the subtree rooted at node idx.
+func (t *xgbTree) depth(idx int) int {
+	node := t.nodes[idx]
+	if node.Flags&isLeaf > 0 {
+		return 0
+	}
+	yes, no := t.depth(node.Yes), t.depth(node.No)
+	if yes > no {
+		return yes + 1
+	}
+	return no + 1
+}
+
+// leaf returns the leaf node a sparse feature vector falls into.
+func (t *xgbTree) leaf(features mat.SparseVector) (*xgbNode, error) {
 	idx := 0
 	for {
 		node := t.nodes[idx]
 		if node == nil {
-			return 0, fmt.Errorf("nil node")
+			return nil, fmt.Errorf("nil node")
 		}
 		if node.Flags&isLeaf > 0 {
-			return node.LeafValues, nil
+			return node, nil
 		}
 		v, ok := features[node.Feature]
-		if !ok {
-			// missing value will be represented as NaN value.
-			idx = node.Missing
-		} else if v >= node.Threshold {
-			idx = node.No
+		idx = node.next(v, ok)
+	}
+}
+
+// leafDense returns the leaf node a dense feature vector falls into.
+func (t *xgbTree) leafDense(features mat.Vector) (*xgbNode, error) {
+	idx := 0
+	for {
+		node := t.nodes[idx]
+		if node == nil {
+			return nil, fmt.Errorf("nil node")
+		}
+		if node.Flags&isLeaf > 0 {
+			return node, nil
+		}
+		idx = node.next(features[node.Feature], true)
+	}
+}
+
+// flatTree stores the nodes of a xgbTree in parallel slices indexed by node id, traversal follows integer
+// offsets instead of node pointers which is friendlier to CPU cache.
+type flatTree struct {
+	flags      []uint8
+	features   []int
+	thresholds []float64
+	yes        []int
+	no         []int
+	missing    []int
+	leafValues []float64
+	categories map[int]map[int]struct{}
+}
+
+// newFlatTree converts a xgbTree to its flat representation.
+func newFlatTree(t *xgbTree) *flatTree {
+	n := len(t.nodes)
+	ft := &flatTree{
+		flags:      make([]uint8, n),
+		features:   make([]int, n),
+		thresholds: make([]float64, n),
+		yes:        make([]int, n),
+		no:         make([]int, n),
+		missing:    make([]int, n),
+		leafValues: make([]float64, n),
+	}
+	for i, node := range t.nodes {
+		if node == nil {
+			ft.flags[i] = isEmpty
+			continue
+		}
+		ft.flags[i] = node.Flags
+		ft.features[i] = node.Feature
+		ft.thresholds[i] = node.Threshold
+		ft.yes[i] = node.Yes
+		ft.no[i] = node.No
+		ft.missing[i] = node.Missing
+		ft.leafValues[i] = node.LeafValues
+		if node.Flags&isCategorical > 0 {
+			if ft.categories == nil {
+				ft.categories = make(map[int]map[int]struct{})
+			}
+			ft.categories[i] = node.Categories
+		}
+	}
+	return ft
+}
+
+// approxMemoryBytes estimates the memory held by the flat tree, category sets are shared with the nodes of the tree.
+func (t *flatTree) approxMemoryBytes() int {
+	size := int(unsafe.Sizeof(*t))
+	size += len(t.flags) + 8*(len(t.features)+len(t.thresholds)+len(t.yes)+len(t.no)+len(t.missing)+len(t.leafValues))
+	if t.categories != nil {
+		size += mapBytes + len(t.categories)*mapEntryBytes
+	}
+	return size
+}
+
+func (t *flatTree) predictDense(features mat.Vector) (float64, error) {
+	idx := 0
+	for {
+		flags := t.flags[idx]
+		if flags&isLeaf > 0 {
+			return t.leafValues[idx], nil
+		}
+		if flags&isEmpty > 0 {
+			return 0, fmt.Errorf("nil node")
+		}
+		v := features[t.features[idx]]
+		if math.IsNaN(v) {
+			idx = t.missing[idx]
+		} else if flags&isCategorical > 0 {
+			if _, ok := t.categories[idx][int(v)]; ok {
+				idx = t.yes[idx]
+			} else {
+				idx = t.no[idx]
+			}
+		} else if v >= t.thresholds[idx] {
+			idx = t.no[idx]
 		} else {
-			idx = node.Yes
+			idx = t.yes[idx]
 		}
 	}
 }
+
+// treeView implements inference.TreeView on top of a tree.
+type treeView struct {
+	tree *xgbTree
+}
+
+// node returns the node with id idx, an empty node if there is none.
+func (v treeView) node(idx int) *xgbNode {
+	if idx < 0 || idx >= len(v.tree.nodes) || v.tree.nodes[idx] == nil {
+		return &xgbNode{}
+	}
+	return v.tree.nodes[idx]
+}
+
+func (v treeView) NumNodes() int {
+	return len(v.tree.nodes)
+}
+
+func (v treeView) HasNode(node int) bool {
+	return node >= 0 && node < len(v.tree.nodes) && v.tree.nodes[node] != nil
+}
+
+func (v treeView) IsLeaf(node int) bool {
+	return v.node(node).Flags&isLeaf > 0
+}
+
+func (v treeView) IsCategorical(node int) bool {
+	return v.node(node).Flags&isCategorical > 0
+}
+
+func (v treeView) Feature(node int) int {
+	return v.node(node).Feature
+}
+
+func (v treeView) Threshold(node int) float64 {
+	return v.node(node).Threshold
+}
+
+func (v treeView) Categories(node int) []int {
+	if !v.IsCategorical(node) {
+		return nil
+	}
+	return v.node(node).sortedCategories()
+}
+
+func (v treeView) Yes(node int) int {
+	return v.node(node).Yes
+}
+
+func (v treeView) No(node int) int {
+	return v.node(node).No
+}
+
+func (v treeView) Missing(node int) int {
+	return v.node(node).Missing
+}
+
+func (v treeView) LeafValue(node int) float64 {
+	return v.node(node).LeafValues
+}
@@ -0,0 +1,310 @@
+package xgboost
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/Elvenson/xgboost-go/inference"
+)
+
+// ubjsonDecoder converts UBJSON, the binary json format written by DMLC XGBoost save_model API since 2.0, into json
+// text so that UBJSON models go through the same loading path as json models.
+type ubjsonDecoder struct {
+	r   *bufio.Reader
+	out bytes.Buffer
+}
+
+// readMarker returns the next type marker, skipping no-op markers.
+func (d *ubjsonDecoder) readMarker() (byte, error) {
+	for {
+		m, err := d.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if m != 'N' {
+			return m, nil
+		}
+	}
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF since UBJSON content never ends in the middle of a value.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readN reads the n next bytes.
+func (d *ubjsonDecoder) readN(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+// readInt reads an integer of type marker m, integers are big endian.
+func (d *ubjsonDecoder) readInt(m byte) (int64, error) {
+	switch m {
+	case 'i', 'U':
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if m == 'i' {
+			return int64(int8(b)), nil
+		}
+		return int64(b), nil
+	case 'I':
+		b, err := d.readN(2)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 'l':
+		b, err := d.readN(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 'L':
+		b, err := d.readN(8)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	default:
+		return 0, fmt.Errorf("expected integer type, got marker %q", m)
+	}
+}
+
+// readLength reads a length or a count, which is an integer value with its type marker.
+func (d *ubjsonDecoder) readLength() (int, error) {
+	m, err := d.readMarker()
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.readInt(m)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+// readString reads the bytes of a string after its type marker.
+func (d *ubjsonDecoder) readString() (string, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(io.LimitReader(d.r, int64(n))); err != nil {
+		return "", err
+	}
+	if b.Len() != n {
+		return "", io.ErrUnexpectedEOF
+	}
+	return b.String(), nil
+}
+
+// writeString writes s as a json string.
+func (d *ubjsonDecoder) writeString(s string) {
+	quoted, _ := json.Marshal(s)
+	d.out.Write(quoted)
+}
+
+// value converts the value of type marker m.
+func (d *ubjsonDecoder) value(m byte) error {
+	switch m {
+	case 'Z':
+		d.out.WriteString("null")
+	case 'T':
+		d.out.WriteString("true")
+	case 'F':
+		d.out.WriteString("false")
+	case 'i', 'U', 'I', 'l', 'L':
+		v, err := d.readInt(m)
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(strconv.FormatInt(v, 10))
+	case 'd', 'D':
+		var v float64
+		bitSize := 32
+		if m == 'd' {
+			b, err := d.readN(4)
+			if err != nil {
+				return err
+			}
+			v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		} else {
+			b, err := d.readN(8)
+			if err != nil {
+				return err
+			}
+			v = math.Float64frombits(binary.BigEndian.Uint64(b))
+			bitSize = 64
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("number %f is not finite", v)
+		}
+		d.out.Write(strconv.AppendFloat(nil, v, 'g', -1, bitSize))
+	case 'H':
+		// high precision number is stored as a string of digits.
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(s)
+	case 'C':
+		b, err := d.readN(1)
+		if err != nil {
+			return err
+		}
+		d.writeString(string(b))
+	case 'S':
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		d.writeString(s)
+	case '[':
+		return d.container(']')
+	case '{':
+		return d.container('}')
+	default:
+		return fmt.Errorf("unknown type marker %q", m)
+	}
+	return nil
+}
+
+// container converts an array or an object after its opening marker, end is the closing marker. Optimized
+// containers declare the type of their values with `$` and their count with `#`, then have no closing marker.
+func (d *ubjsonDecoder) container(end byte) error {
+	isObject := end == '}'
+	if isObject {
+		d.out.WriteByte('{')
+	} else {
+		d.out.WriteByte('[')
+	}
+
+	var valueType byte
+	count := -1
+	m, err := d.readMarker()
+	if err != nil {
+		return err
+	}
+	if m == '$' {
+		if valueType, err = d.readMarker(); err != nil {
+			return err
+		}
+		if m, err = d.readMarker(); err != nil {
+			return err
+		}
+		if m != '#' {
+			return fmt.Errorf("typed container must have a count, got marker %q", m)
+		}
+	}
+	if m == '#' {
+		if count, err = d.readLength(); err != nil {
+			return err
+		}
+	} else if err := d.r.UnreadByte(); err != nil {
+		return err
+	}
+
+	for i := 0; count < 0 || i < count; i++ {
+		if count < 0 {
+			if m, err = d.readMarker(); err != nil {
+				return err
+			}
+			if m == end {
+				break
+			}
+			if err := d.r.UnreadByte(); err != nil {
+				return err
+			}
+		}
+		if i > 0 {
+			d.out.WriteByte(',')
+		}
+		if isObject {
+			// keys are strings without type marker.
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+			d.writeString(key)
+			d.out.WriteByte(':')
+		}
+		m := valueType
+		if m == 0 {
+			if m, err = d.readMarker(); err != nil {
+				return err
+			}
+		}
+		if err := d.value(m); err != nil {
+			return err
+		}
+	}
+
+	d.out.WriteByte(end)
+	return nil
+}
+
+// ubjsonToJSON converts UBJSON content into json text.
+func ubjsonToJSON(r io.Reader) ([]byte, error) {
+	d := &ubjsonDecoder{r: bufio.NewReader(r)}
+	m, err := d.readMarker()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.value(m); err != nil {
+		return nil, fmt.Errorf("cannot decode ubjson: %w", err)
+	}
+	return d.out.Bytes(), nil
+}
+
+// LoadXGBoostFromUBJSON loads xgboost model from a UBJSON file generated by save_model API, the default format of
+// DMLC XGBoost since 2.0 with `.ubj` extension. Like LoadXGBoostFromSaveModelJSON, the number of classes, number of
+// parallel trees, base score and activation are read from the model.
+func LoadXGBoostFromUBJSON(modelPath string, opts ...LoadOption) (*inference.Ensemble, error) {
+	modelFile, err := os.Open(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	defer modelFile.Close()
+
+	return LoadXGBoostFromUBJSONReader(modelFile, opts...)
+}
+
+// LoadXGBoostFromUBJSONReader loads xgboost model from a reader of UBJSON content generated by save_model API, see
+// LoadXGBoostFromUBJSON. Gzip compressed content is detected and decompressed transparently. The reader is not
+// closed.
+func LoadXGBoostFromUBJSONReader(r io.Reader, opts ...LoadOption) (*inference.Ensemble, error) {
+	modelReader, err := decompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ubjsonToJSON(modelReader)
+	if err != nil {
+		return nil, err
+	}
+
+	var model saveModelJSON
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, err
+	}
+	return loadSaveModel(&model, 0, 0, nil, opts...)
+}
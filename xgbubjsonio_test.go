@@ -0,0 +1,189 @@
+package xgboost
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/Elvenson/xgboost-go/mat"
+)
+
+// encodeUBJSON encodes a json value decoded with UseNumber into UBJSON like DMLC XGBoost: arrays of numbers are
+// typed arrays of int32 or float32, other arrays and objects have a closing marker.
+func encodeUBJSON(tb testing.TB, buf *bytes.Buffer, v interface{}) {
+	writeLength := func(n int) {
+		if n < 256 {
+			buf.WriteByte('U')
+			buf.WriteByte(byte(n))
+			return
+		}
+		buf.WriteByte('l')
+		assert.NilError(tb, binary.Write(buf, binary.BigEndian, int32(n)))
+	}
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte('Z')
+	case bool:
+		if v {
+			buf.WriteByte('T')
+		} else {
+			buf.WriteByte('F')
+		}
+	case json.Number:
+		i, err := v.Int64()
+		if err == nil {
+			buf.WriteByte('L')
+			assert.NilError(tb, binary.Write(buf, binary.BigEndian, i))
+			return
+		}
+		f, err := v.Float64()
+		assert.NilError(tb, err)
+		buf.WriteByte('D')
+		assert.NilError(tb, binary.Write(buf, binary.BigEndian, f))
+	case string:
+		buf.WriteByte('S')
+		writeLength(len(v))
+		buf.WriteString(v)
+	case []interface{}:
+		buf.WriteByte('[')
+		if numbers, ints := numberArray(v); numbers && len(v) > 0 {
+			if ints {
+				buf.WriteString("$l#")
+			} else {
+				buf.WriteString("$d#")
+			}
+			writeLength(len(v))
+			for _, n := range v {
+				if ints {
+					i, _ := n.(json.Number).Int64()
+					assert.NilError(tb, binary.Write(buf, binary.BigEndian, int32(i)))
+				} else {
+					f, _ := n.(json.Number).Float64()
+					assert.NilError(tb, binary.Write(buf, binary.BigEndian, float32(f)))
+				}
+			}
+			return
+		}
+		for _, e := range v {
+			encodeUBJSON(tb, buf, e)
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		buf.WriteByte('{')
+		for k, e := range v {
+			writeLength(len(k))
+			buf.WriteString(k)
+			encodeUBJSON(tb, buf, e)
+		}
+		buf.WriteByte('}')
+	default:
+		tb.Fatalf("cannot encode %T", v)
+	}
+}
+
+// numberArray reports whether all values of an array are numbers and whether they are all integers.
+func numberArray(v []interface{}) (numbers bool, ints bool) {
+	ints = true
+	for _, e := range v {
+		n, ok := e.(json.Number)
+		if !ok {
+			return false, false
+		}
+		if strings.ContainsAny(n.String(), ".eE") {
+			ints = false
+		}
+	}
+	return true, ints
+}
+
+// toUBJSON converts a json file into UBJSON.
+func toUBJSON(tb testing.TB, jsonPath string) []byte {
+	data, err := ioutil.ReadFile(jsonPath)
+	assert.NilError(tb, err)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	assert.NilError(tb, dec.Decode(&v))
+	var buf bytes.Buffer
+	encodeUBJSON(tb, &buf, v)
+	return buf.Bytes()
+}
+
+func TestLoadXGBoostFromUBJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		modelPath string
+		inputPath string
+	}{
+		{"iris", "test/data/iris_xgboost_save_model.json", "test/data/iris_test.libsvm"},
+		{"breast cancer", "test/data/breast_cancer_xgboost_save_model.json", "test/data/breast_cancer_test.libsvm"},
+		{"regression", "test/data/breast_cancer_xgboost_save_model_regression.json",
+			"test/data/breast_cancer_test.libsvm"},
+	}
+	dir, err := ioutil.TempDir("", "xgboost")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range tests {
+		expected, err := LoadXGBoostFromSaveModelJSON(tc.modelPath)
+		assert.NilError(t, err)
+		modelPath := filepath.Join(dir, filepath.Base(tc.modelPath)+".ubj")
+		assert.NilError(t, ioutil.WriteFile(modelPath, toUBJSON(t, tc.modelPath), 0600))
+		ensemble, err := LoadXGBoostFromUBJSON(modelPath)
+		assert.NilError(t, err, tc.name)
+		assert.Equal(t, ensemble.NumClasses(), expected.NumClasses(), tc.name)
+		assert.Equal(t, ensemble.NumTrees(), expected.NumTrees(), tc.name)
+		assert.Equal(t, ensemble.Type(), expected.Type(), tc.name)
+		assert.Equal(t, ensemble.BaseScore, expected.BaseScore, tc.name)
+
+		input, err := mat.ReadLibsvmFileToSparseMatrix(tc.inputPath)
+		assert.NilError(t, err)
+		predictions, err := ensemble.PredictProba(input)
+		assert.NilError(t, err)
+		expectedProba, err := expected.PredictProba(input)
+		assert.NilError(t, err)
+		// the json files hold more digits than the float32 values of the UBJSON encoding.
+		assert.NilError(t, mat.IsEqualMatrices(&predictions, &expectedProba, 1e-6), tc.name)
+	}
+}
+
+func TestUBJSONToJSON(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("{U\x01aN[$i#U\x03\x01\xff\x7f" + "U\x01b[#U\x03CxTZ" + "U\x01cSU\x02\"\\" + "U\x01dH" + "U\x031e3")
+	buf.WriteString("U\x01e[$d#U\x01")
+	assert.NilError(t, binary.Write(&buf, binary.BigEndian, float32(0.1)))
+	buf.WriteString("U\x01fI\xff\xfe}")
+	data, err := ubjsonToJSON(&buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), `{"a":[1,-1,127],"b":["x",true,null],"c":"\"\\","d":1e3,"e":[0.1],"f":-2}`)
+
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"[U", "unexpected EOF"},
+		{"{U\x05ab", "unexpected EOF"},
+		{"[$d#", "unexpected EOF"},
+		{"[$dU", "typed container must have a count"},
+		{"[X]", "unknown type marker 'X'"},
+		{"[#l\xff\xff\xff\xff", "invalid length -1"},
+		{"[#S", "expected integer type"},
+		{"[D\x7f\xf8\x00\x00\x00\x00\x00\x00]", "is not finite"},
+	}
+	for _, tc := range tests {
+		_, err := ubjsonToJSON(strings.NewReader(tc.content))
+		assert.ErrorContains(t, err, tc.err, "%q", tc.content)
+	}
+
+	_, err = LoadXGBoostFromUBJSONReader(strings.NewReader("[Z]"))
+	assert.ErrorContains(t, err, "cannot unmarshal array")
+	_, err = LoadXGBoostFromUBJSON("test/data/missing.ubj")
+	assert.Assert(t, os.IsNotExist(err))
+}